@@ -16,255 +16,358 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"time"
 
+	"github.com/davecgh/go-spew/spew"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
 	corev1 "k8s.io/api/core/v1"
-	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/fields"
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
 	demov1 "k8s.io/bgd-operator/pkg/apis/demo/v1"
 	"k8s.io/client-go/kubernetes"
-	typedv1beta1 "k8s.io/client-go/kubernetes/typed/extensions/v1beta1"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/cache"
-	"k8s.io/client-go/util/retry"
 )
 
-func CrdClient(c kubernetes.Interface, cl *rest.RESTClient, scheme *runtime.Scheme, namespace string) *crdclient {
-	return &crdclient{c: c, cl: cl, ns: namespace, plural: "bgdeployments",
-		codec: runtime.NewParameterCodec(scheme)}
+// kubeclient creates and updates the replicasets and services of
+// BGDeployments. BGDeployments themselves go through the generated clientset.
+type kubeclient struct {
+	c kubernetes.Interface
 }
 
-type crdclient struct {
-	c      kubernetes.Interface
-	cl     *rest.RESTClient
-	ns     string
-	plural string
-	codec  runtime.ParameterCodec
+// KubeClient returns a kubeclient on top of the given clientset
+func KubeClient(c kubernetes.Interface) *kubeclient {
+	return &kubeclient{c: c}
 }
 
-func (f *crdclient) Create(obj *demov1.BGDeployment) (*demov1.BGDeployment, *extensionsv1beta1.ReplicaSet, error) {
-	var result demov1.BGDeployment
-	err := f.cl.Post().
-		Namespace(f.ns).Resource(f.plural).
-		Body(obj).Do().Into(&result)
-	if err != nil {
-		return &result, nil, err
-	}
+const (
+	// bgdLabel is set on every object created for a BGDeployment, with the
+	// name of the BGDeployment as value, so that the selectors of different
+	// BGDeployments in a namespace never overlap.
+	bgdLabel = "demo.google.com/bgdeployment"
+	// colorLabel tells the blue and green replicasets of a BGDeployment apart
+	colorLabel = "color"
+	// templateHashLabel is set on a RS and its pods, with the hash of the pod
+	// template of the BGDeployment the RS was created from as value
+	templateHashLabel = "bgd-template-hash"
+	// activeSinceAnnotation is set on a RS once the service sends traffic to
+	// it, with the time it started to as value
+	activeSinceAnnotation = "demo.google.com/active-since"
+)
 
-	// Create a RS along with CRD creation
-	rs, err := f.CreateReplicaSet("blue-rs", "blue", obj)
-	return &result, rs, err
+// computeHash returns a hash value calculated from the pod template, which is
+// used to detect changes of the template of a BGDeployment
+func computeHash(template *corev1.PodTemplateSpec) string {
+	hasher := fnv.New32a()
+	printer := spew.ConfigState{
+		Indent:         " ",
+		SortKeys:       true,
+		DisableMethods: true,
+		SpewKeys:       true,
+	}
+	printer.Fprintf(hasher, "%#v", *template)
+	return fmt.Sprint(hasher.Sum32())
 }
 
-func (f *crdclient) Update(obj *demov1.BGDeployment) (*demov1.BGDeployment, error) {
-	var result demov1.BGDeployment
-	err := f.cl.Put().
-		Namespace(f.ns).Resource(f.plural).
-		Body(obj).Do().Into(&result)
-	return &result, err
+// podTemplate returns the pod template of the BGDeployment. A BGDeployment
+// created before spec.template existed only has the deprecated spec.image,
+// which stands for a single nginx container running that image.
+func podTemplate(obj *demov1.BGDeployment) *corev1.PodTemplateSpec {
+	if len(obj.Spec.Template.Spec.Containers) > 0 || obj.Spec.Image == "" {
+		return &obj.Spec.Template
+	}
+	template := obj.Spec.Template.DeepCopy()
+	template.Spec.Containers = []corev1.Container{{Name: "nginx", Image: obj.Spec.Image}}
+	return template
 }
 
-func (f *crdclient) Delete(name string, options *metav1.DeleteOptions) error {
-	return f.cl.Delete().
-		Namespace(f.ns).Resource(f.plural).
-		Name(name).Body(options).Do().
-		Error()
+// bgdLabels returns the labels of the objects of the given color that belong
+// to the BGDeployment
+func bgdLabels(obj *demov1.BGDeployment, color string) map[string]string {
+	return map[string]string{bgdLabel: obj.Name, colorLabel: color}
 }
 
-func (f *crdclient) Get(name string) (*demov1.BGDeployment, error) {
-	var result demov1.BGDeployment
-	err := f.cl.Get().
-		Namespace(f.ns).Resource(f.plural).
-		Name(name).Do().Into(&result)
-	return &result, err
+// bgdReplicas returns the desired number of replicas of the BGDeployment,
+// which defaults to 1
+func bgdReplicas(obj *demov1.BGDeployment) int32 {
+	if obj.Spec.Replicas == nil {
+		return 1
+	}
+	return *obj.Spec.Replicas
 }
 
-func (f *crdclient) List(opts metav1.ListOptions) (*demov1.BGDeploymentList, error) {
-	var result demov1.BGDeploymentList
-	err := f.cl.Get().
-		Namespace(f.ns).Resource(f.plural).
-		VersionedParams(&opts, f.codec).
-		Do().Into(&result)
-	return &result, err
-}
+// newReplicaSet returns a RS of the given color for the BGDeployment. The
+// name of the RS is generated by the API server from the name of the
+// BGDeployment and the color, so that it never collides with another RS.
+func newReplicaSet(color string, obj *demov1.BGDeployment) *appsv1.ReplicaSet {
+	replicas := bgdReplicas(obj)
+	hash := computeHash(podTemplate(obj))
 
-// Create a new List watch for custom resource
-func (f *crdclient) NewListWatch() *cache.ListWatch {
-	return cache.NewListWatchFromClient(f.cl, f.plural, f.ns, fields.Everything())
-}
+	rsLabels := bgdLabels(obj, color)
+	rsLabels[templateHashLabel] = hash
+
+	template := podTemplate(obj).DeepCopy()
+	if template.Labels == nil {
+		template.Labels = map[string]string{}
+	}
+	for k, v := range rsLabels {
+		template.Labels[k] = v
+	}
 
-func newReplicaSet(name, color string, obj *demov1.BGDeployment) *extensionsv1beta1.ReplicaSet {
-	one := int32(1)
-	return &extensionsv1beta1.ReplicaSet{
+	return &appsv1.ReplicaSet{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "ReplicaSet",
-			APIVersion: "extensions/v1beta1",
+			APIVersion: "apps/v1",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: obj.Namespace,
+			GenerateName: fmt.Sprintf("%s-%s-", obj.Name, color),
+			Namespace:    obj.Namespace,
+			Labels:       rsLabels,
 			OwnerReferences: []metav1.OwnerReference{
-				*metav1.NewControllerRef(obj, schema.GroupVersionKind{
-					Group:   demov1.SchemeGroupVersion.Group,
-					Version: demov1.SchemeGroupVersion.Version,
-					Kind:    "BGDeployment",
-				}),
+				*metav1.NewControllerRef(obj, controllerKind),
 			},
 		},
-		Spec: extensionsv1beta1.ReplicaSetSpec{
+		Spec: appsv1.ReplicaSetSpec{
 			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{"color": color},
-			},
-			Replicas: &one,
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{"color": color},
-				},
-				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{
-						{
-							Name:  "nginx",
-							Image: obj.Spec.Image,
-						},
-					},
-				},
+				MatchLabels: bgdLabels(obj, color),
 			},
+			Replicas:        &replicas,
+			MinReadySeconds: obj.Spec.MinReadySeconds,
+			Template:        *template,
 		},
 	}
 }
 
-func (f *crdclient) CreateReplicaSet(name, color string, obj *demov1.BGDeployment) (*extensionsv1beta1.ReplicaSet, error) {
-	return f.c.ExtensionsV1beta1().ReplicaSets(obj.Namespace).Create(newReplicaSet(name, color, obj))
+func (f *kubeclient) CreateReplicaSet(color string, obj *demov1.BGDeployment) (*appsv1.ReplicaSet, error) {
+	return f.c.AppsV1().ReplicaSets(obj.Namespace).Create(newReplicaSet(color, obj))
+}
+
+func (f *kubeclient) GetReplicaSet(name, namespace string) (*appsv1.ReplicaSet, error) {
+	return f.c.AppsV1().ReplicaSets(namespace).Get(name, metav1.GetOptions{})
 }
 
-func (f *crdclient) ListReplicaSet(namespace string) (*extensionsv1beta1.ReplicaSetList, error) {
-	return f.c.ExtensionsV1beta1().ReplicaSets(namespace).List(metav1.ListOptions{})
+func (f *kubeclient) ListReplicaSet(namespace string, selector labels.Selector) (*appsv1.ReplicaSetList, error) {
+	return f.c.AppsV1().ReplicaSets(namespace).List(metav1.ListOptions{LabelSelector: selector.String()})
 }
 
-func (f *crdclient) DeleteReplicaSet(rs *extensionsv1beta1.ReplicaSet) error {
+func (f *kubeclient) PatchReplicaSet(name, namespace string, data []byte) (*appsv1.ReplicaSet, error) {
+	return f.c.AppsV1().ReplicaSets(namespace).Patch(name, types.StrategicMergePatchType, data)
+}
+
+func (f *kubeclient) DeleteReplicaSet(rs *appsv1.ReplicaSet) error {
 	background := metav1.DeletePropagationBackground
-	return f.c.ExtensionsV1beta1().ReplicaSets(rs.Namespace).Delete(rs.Name, &metav1.DeleteOptions{PropagationPolicy: &background})
+	return f.c.AppsV1().ReplicaSets(rs.Namespace).Delete(rs.Name, &metav1.DeleteOptions{PropagationPolicy: &background})
+}
+
+// serviceName returns the name of the service of the BGDeployment
+func serviceName(obj *demov1.BGDeployment) string {
+	if obj.Spec.Service.Name != "" {
+		return obj.Spec.Service.Name
+	}
+	return obj.Name
+}
+
+// previewServiceName returns the name of the preview service of the
+// BGDeployment
+func previewServiceName(obj *demov1.BGDeployment) string {
+	if obj.Spec.PreviewService != nil && obj.Spec.PreviewService.Name != "" {
+		return obj.Spec.PreviewService.Name
+	}
+	return obj.Name + "-preview"
+}
+
+// newService returns the service of the BGDeployment described by its spec,
+// selecting the pods of the given color
+func newService(obj *demov1.BGDeployment, color string) *corev1.Service {
+	return buildService(obj, &obj.Spec.Service, serviceName(obj), color)
+}
+
+// newPreviewService returns the preview service of the BGDeployment described
+// by its spec, selecting the pods of the given color
+func newPreviewService(obj *demov1.BGDeployment, color string) *corev1.Service {
+	spec := obj.Spec.PreviewService.DeepCopy()
+	if len(spec.Ports) == 0 {
+		for _, port := range obj.Spec.Service.Ports {
+			port.NodePort = 0
+			spec.Ports = append(spec.Ports, port)
+		}
+	}
+	return buildService(obj, spec, previewServiceName(obj), color)
 }
 
-func newService(namespace string) *corev1.Service {
-	labels := map[string]string{"color": "blue"}
+// buildService returns a service called name, described by spec, selecting the
+// pods of the given color of the BGDeployment
+func buildService(obj *demov1.BGDeployment, spec *demov1.BGDeploymentServiceSpec, name, color string) *corev1.Service {
+
+	labels := map[string]string{}
+	for k, v := range spec.Labels {
+		labels[k] = v
+	}
+	for k, v := range bgdLabels(obj, color) {
+		labels[k] = v
+	}
+
+	ports := make([]corev1.ServicePort, 0, len(spec.Ports))
+	for _, port := range spec.Ports {
+		// Fill in the defaults of the API server, so that the ports can be
+		// compared with the ones of an existing service
+		if port.Protocol == "" {
+			port.Protocol = corev1.ProtocolTCP
+		}
+		if port.TargetPort.Type == intstr.Int && port.TargetPort.IntVal == 0 {
+			port.TargetPort = intstr.FromInt(int(port.Port))
+		}
+		ports = append(ports, port)
+	}
+	if len(ports) == 0 {
+		ports = append(ports, corev1.ServicePort{
+			Protocol:   corev1.ProtocolTCP,
+			Port:       80,
+			TargetPort: intstr.FromInt(443),
+		})
+	}
+
+	serviceType := spec.Type
+	if serviceType == "" {
+		serviceType = corev1.ServiceTypeClusterIP
+	}
+	sessionAffinity := spec.SessionAffinity
+	if sessionAffinity == "" {
+		sessionAffinity = corev1.ServiceAffinityNone
+	}
+
 	return &corev1.Service{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Service",
 			APIVersion: "core/v1",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "bgd-svc",
-			Namespace: namespace,
-			Labels:    labels,
+			Name:        name,
+			Namespace:   obj.Namespace,
+			Labels:      labels,
+			Annotations: spec.Annotations,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(obj, controllerKind),
+			},
 		},
 		Spec: corev1.ServiceSpec{
-			Selector: labels,
-			Ports: []corev1.ServicePort{
-				{
-					Protocol:   "TCP",
-					Port:       80,
-					TargetPort: intstr.FromInt(443),
-				},
-			},
+			Selector:        bgdLabels(obj, color),
+			Type:            serviceType,
+			Ports:           ports,
+			SessionAffinity: sessionAffinity,
 		},
 	}
 }
 
-func (f *crdclient) CreateService(namespace string) (*corev1.Service, error) {
-	return f.c.CoreV1().Services(namespace).Create(newService(namespace))
-}
+// applyService copies the fields of the desired service the operator manages
+// onto svc. Labels and annotations added by others are kept, and so are the
+// node ports allocated by the API server that the desired service leaves
+// unset. A service created before services had an owner is given one.
+func applyService(svc, desired *corev1.Service) {
+	if metav1.GetControllerOf(svc) == nil {
+		svc.OwnerReferences = append(svc.OwnerReferences, desired.OwnerReferences...)
+	}
 
-func (f *crdclient) UpdateService(svcName, namespace string, updateFunc func(*corev1.Service)) (*corev1.Service, error) {
-	var svc *corev1.Service
-	svcClient := f.c.CoreV1().Services(namespace)
-	if err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
-		newSvc, err := svcClient.Get(svcName, metav1.GetOptions{})
-		if err != nil {
-			return err
+	if svc.Labels == nil {
+		svc.Labels = map[string]string{}
+	}
+	for k, v := range desired.Labels {
+		svc.Labels[k] = v
+	}
+	if len(desired.Annotations) > 0 && svc.Annotations == nil {
+		svc.Annotations = map[string]string{}
+	}
+	for k, v := range desired.Annotations {
+		svc.Annotations[k] = v
+	}
+
+	ports := make([]corev1.ServicePort, len(desired.Spec.Ports))
+	copy(ports, desired.Spec.Ports)
+	if desired.Spec.Type != corev1.ServiceTypeClusterIP {
+		for i := range ports {
+			if ports[i].NodePort != 0 {
+				continue
+			}
+			for _, current := range svc.Spec.Ports {
+				if current.Port == ports[i].Port && current.Protocol == ports[i].Protocol {
+					ports[i].NodePort = current.NodePort
+				}
+			}
 		}
-		updateFunc(newSvc)
-		svc, err = svcClient.Update(newSvc)
-		return err
-	}); err != nil {
-		return nil, err
 	}
-	return svc, nil
+
+	svc.Spec.Selector = desired.Spec.Selector
+	svc.Spec.Type = desired.Spec.Type
+	svc.Spec.Ports = ports
+	svc.Spec.SessionAffinity = desired.Spec.SessionAffinity
 }
 
-func (f *crdclient) DeleteService(svc *corev1.Service) error {
-	return f.c.CoreV1().Services(svc.Namespace).Delete(svc.Name, &metav1.DeleteOptions{})
+func (f *kubeclient) CreateService(svc *corev1.Service) (*corev1.Service, error) {
+	return f.c.CoreV1().Services(svc.Namespace).Create(svc)
 }
 
-// waitAllPodsAvailable returns true if all pods are available, false otherwise
-func (f *crdclient) WaitAllPodsAvailable(rs *extensionsv1beta1.ReplicaSet, pollInterval, pollTimeout time.Duration) bool {
-	if err := wait.PollImmediate(pollInterval, pollTimeout, func() (bool, error) {
-		newRS, err := f.c.ExtensionsV1beta1().ReplicaSets(rs.Namespace).Get(rs.Name, metav1.GetOptions{})
-		if err != nil {
-			return false, err
-		}
-		return newRS.Status.Replicas == *rs.Spec.Replicas && newRS.Status.AvailableReplicas == *rs.Spec.Replicas, nil
-	}); err != nil {
-		fmt.Printf("failed to wait for all pods of replicaset %q to be available: %v\n", rs.Name, err)
-		return false
-	}
-	return true
+func (f *kubeclient) GetService(name, namespace string) (*corev1.Service, error) {
+	return f.c.CoreV1().Services(namespace).Get(name, metav1.GetOptions{})
 }
 
-func updateRS(rsClient typedv1beta1.ReplicaSetInterface, rsName string, updateFunc func(*extensionsv1beta1.ReplicaSet)) (*extensionsv1beta1.ReplicaSet, error) {
-	var rs *extensionsv1beta1.ReplicaSet
-	if err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
-		newRS, err := rsClient.Get(rsName, metav1.GetOptions{})
-		if err != nil {
-			return err
-		}
-		updateFunc(newRS)
-		rs, err = rsClient.Update(newRS)
-		return err
-	}); err != nil {
-		return nil, fmt.Errorf("Failed to update rs %s: %v", rsName, err)
-	}
-	return rs, nil
+// PatchService applies a strategic merge patch to the service, which only
+// touches the fields it holds
+func (f *kubeclient) PatchService(name, namespace string, data []byte) (*corev1.Service, error) {
+	return f.c.CoreV1().Services(namespace).Patch(name, types.StrategicMergePatchType, data)
 }
 
-func (f *crdclient) ScaleReplicaSet(rs *extensionsv1beta1.ReplicaSet, replicas int32) error {
-	rsClient := f.c.ExtensionsV1beta1().ReplicaSets(rs.Namespace)
-	rs, err := updateRS(rsClient, rs.Name, func(rs *extensionsv1beta1.ReplicaSet) {
-		*rs.Spec.Replicas = replicas
-	})
+// servicePatch returns a strategic merge patch made of the fields that differ
+// between svc and updated, so that it leaves the fields set by others alone
+func servicePatch(svc, updated *corev1.Service) ([]byte, error) {
+	oldData, err := json.Marshal(svc)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if !f.WaitAllPodsAvailable(rs, 100*time.Millisecond, 5*time.Second) {
-		return fmt.Errorf("failed to scale down RS %q", rs.Name)
+	newData, err := json.Marshal(updated)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return strategicpatch.CreateTwoWayMergePatch(oldData, newData, corev1.Service{})
 }
 
-func NewClient(cfg *rest.Config) (*rest.RESTClient, *runtime.Scheme, error) {
-	scheme := runtime.NewScheme()
-	SchemeBuilder := runtime.NewSchemeBuilder(demov1.AddKnownTypes)
-	if err := SchemeBuilder.AddToScheme(scheme); err != nil {
-		return nil, nil, err
-	}
-	config := *cfg
-	config.GroupVersion = &demov1.SchemeGroupVersion
-	config.APIPath = "/apis"
-	config.ContentType = runtime.ContentTypeJSON
-	config.NegotiatedSerializer = serializer.DirectCodecFactory{
-		CodecFactory: serializer.NewCodecFactory(scheme),
+func (f *kubeclient) ListService(namespace string, selector labels.Selector) (*corev1.ServiceList, error) {
+	return f.c.CoreV1().Services(namespace).List(metav1.ListOptions{LabelSelector: selector.String()})
+}
+
+func (f *kubeclient) DeleteService(name, namespace string) error {
+	return f.c.CoreV1().Services(namespace).Delete(name, &metav1.DeleteOptions{})
+}
+
+// ScaleReplicaSet sets the number of replicas of the RS through its scale
+// subresource, which leaves the rest of the RS alone
+func (f *kubeclient) ScaleReplicaSet(rs *appsv1.ReplicaSet, replicas int32) error {
+	scale := &autoscalingv1.Scale{
+		ObjectMeta: metav1.ObjectMeta{Name: rs.Name, Namespace: rs.Namespace},
+		Spec:       autoscalingv1.ScaleSpec{Replicas: replicas},
 	}
+	_, err := f.c.AppsV1().ReplicaSets(rs.Namespace).UpdateScale(rs.Name, scale)
+	return err
+}
 
-	client, err := rest.RESTClientFor(&config)
-	if err != nil {
-		return nil, nil, err
+// MarkReplicaSetActive records on the RS that the service sends traffic to it
+// since the given time
+func (f *kubeclient) MarkReplicaSetActive(rs *appsv1.ReplicaSet, since metav1.Time) error {
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, activeSinceAnnotation, since.UTC().Format(time.RFC3339))
+	_, err := f.PatchReplicaSet(rs.Name, rs.Namespace, []byte(patch))
+	return err
+}
+
+// RestoreReplicaSet labels a RS with the hash of the template it is rolled
+// back to, and scales it back up to the given number of replicas
+func (f *kubeclient) RestoreReplicaSet(rs *appsv1.ReplicaSet, hash string, replicas int32) error {
+	patch := fmt.Sprintf(`{"metadata":{"labels":{%q:%q}}}`, templateHashLabel, hash)
+	if _, err := f.PatchReplicaSet(rs.Name, rs.Namespace, []byte(patch)); err != nil {
+		return err
 	}
-	return client, scheme, nil
+	return f.ScaleReplicaSet(rs, replicas)
 }
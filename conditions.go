@@ -0,0 +1,189 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	demov1 "k8s.io/bgd-operator/pkg/apis/demo/v1"
+)
+
+// Reasons for the Progressing condition of a BGDeployment, named after the
+// ones of Deployments
+const (
+	// newRSCreatedReason is added when a new RS is created for a rollout
+	newRSCreatedReason = "NewReplicaSetCreated"
+	// rsUpdatedReason is added when an existing RS is rolled out again,
+	// e.g. when rolling back to it
+	rsUpdatedReason = "ReplicaSetUpdated"
+	// newRSAvailableReason is added when the pods of the new RS are all
+	// available
+	newRSAvailableReason = "NewReplicaSetAvailable"
+	// timedOutReason is added when the pods of the new RS did not become
+	// available within the progress deadline
+	timedOutReason = "ProgressDeadlineExceeded"
+)
+
+// Reasons for the Available and Degraded conditions of a BGDeployment
+const (
+	minimumReplicasAvailable   = "MinimumReplicasAvailable"
+	minimumReplicasUnavailable = "MinimumReplicasUnavailable"
+	syncSucceededReason        = "SyncSucceeded"
+	syncFailedReason           = "SyncFailed"
+	rolloutFailedReason        = "RolloutFailed"
+)
+
+// defaultProgressDeadline is the progress deadline of a BGDeployment that
+// does not set spec.progressDeadlineSeconds
+const defaultProgressDeadline = 600 * time.Second
+
+// progressDeadline returns the time the pods of a new RS of the BGDeployment
+// are given to become available
+func progressDeadline(bgd *demov1.BGDeployment) time.Duration {
+	if bgd.Spec.ProgressDeadlineSeconds == nil {
+		return defaultProgressDeadline
+	}
+	return time.Duration(*bgd.Spec.ProgressDeadlineSeconds) * time.Second
+}
+
+// newCondition creates a new BGDeployment condition
+func newCondition(condType demov1.BGDeploymentConditionType, status corev1.ConditionStatus, reason, message string) demov1.BGDeploymentCondition {
+	return demov1.BGDeploymentCondition{
+		Type:               condType,
+		Status:             status,
+		LastUpdateTime:     metav1.Now(),
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            message,
+	}
+}
+
+// getCondition returns the condition with the provided type
+func getCondition(status demov1.BGDeploymentStatus, condType demov1.BGDeploymentConditionType) *demov1.BGDeploymentCondition {
+	for i := range status.Conditions {
+		if status.Conditions[i].Type == condType {
+			return &status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// setCondition updates the status to include the provided condition. If the
+// condition that we are about to add already exists with the same status,
+// reason and message, nothing changes. The transition time is only moved
+// when the status of the condition changes.
+func setCondition(status *demov1.BGDeploymentStatus, condition demov1.BGDeploymentCondition) {
+	current := getCondition(*status, condition.Type)
+	if current != nil && current.Status == condition.Status && current.Reason == condition.Reason && current.Message == condition.Message {
+		return
+	}
+	if current != nil && current.Status == condition.Status {
+		condition.LastTransitionTime = current.LastTransitionTime
+	}
+	conditions := filterOutCondition(status.Conditions, condition.Type)
+	status.Conditions = append(conditions, condition)
+}
+
+// filterOutCondition returns a new slice of conditions without conditions of
+// the provided type
+func filterOutCondition(conditions []demov1.BGDeploymentCondition, condType demov1.BGDeploymentConditionType) []demov1.BGDeploymentCondition {
+	var newConditions []demov1.BGDeploymentCondition
+	for _, c := range conditions {
+		if c.Type == condType {
+			continue
+		}
+		newConditions = append(newConditions, c)
+	}
+	return newConditions
+}
+
+// rolloutInProgress returns the Progressing condition when it tracks a rollout
+// that is not over yet, nil otherwise. The LastUpdateTime of the condition is
+// when the rollout started.
+func rolloutInProgress(status demov1.BGDeploymentStatus) *demov1.BGDeploymentCondition {
+	cond := getCondition(status, demov1.BGDeploymentProgressing)
+	if cond == nil || cond.Status != corev1.ConditionTrue {
+		return nil
+	}
+	if cond.Reason != newRSCreatedReason && cond.Reason != rsUpdatedReason {
+		return nil
+	}
+	return cond
+}
+
+// replicaSetAvailable returns whether all the pods of the RS are available,
+// as observed by the replicaset controller
+func replicaSetAvailable(rs *appsv1.ReplicaSet) bool {
+	return rs.Status.ObservedGeneration >= rs.Generation &&
+		rs.Status.Replicas == *rs.Spec.Replicas &&
+		rs.Status.AvailableReplicas == *rs.Spec.Replicas
+}
+
+// setProgressing sets the Progressing condition of a BGDeployment for the
+// given reason and RS. It is false only when the progress deadline of the
+// rollout was exceeded.
+func setProgressing(status *demov1.BGDeploymentStatus, reason string, rs *appsv1.ReplicaSet) {
+	condStatus, message := corev1.ConditionTrue, fmt.Sprintf("ReplicaSet %q is progressing.", rs.Name)
+	switch reason {
+	case newRSCreatedReason:
+		message = fmt.Sprintf("Created new replica set %q", rs.Name)
+	case newRSAvailableReason:
+		message = fmt.Sprintf("ReplicaSet %q has successfully progressed.", rs.Name)
+	case timedOutReason:
+		condStatus, message = corev1.ConditionFalse, timedOutMessage(rs)
+	}
+	setCondition(status, newCondition(demov1.BGDeploymentProgressing, condStatus, reason, message))
+}
+
+// timedOutMessage returns the message of the Progressing condition of a
+// rollout whose RS timed out progressing
+func timedOutMessage(rs *appsv1.ReplicaSet) string {
+	return fmt.Sprintf("ReplicaSet %q has timed out progressing.", rs.Name)
+}
+
+// rolloutTimedOut returns whether the Progressing condition records that the
+// pods of the RS did not become available within the progress deadline
+func rolloutTimedOut(status demov1.BGDeploymentStatus, rs *appsv1.ReplicaSet) bool {
+	cond := getCondition(status, demov1.BGDeploymentProgressing)
+	return cond != nil && cond.Status == corev1.ConditionFalse && cond.Reason == timedOutReason && cond.Message == timedOutMessage(rs)
+}
+
+// setAvailable sets the Available condition of a BGDeployment from the RS of
+// the active color
+func setAvailable(status *demov1.BGDeploymentStatus, activeRS *appsv1.ReplicaSet) {
+	if activeRS.Status.AvailableReplicas >= *activeRS.Spec.Replicas {
+		setCondition(status, newCondition(demov1.BGDeploymentAvailable, corev1.ConditionTrue, minimumReplicasAvailable, "BGDeployment has minimum availability."))
+		return
+	}
+	setCondition(status, newCondition(demov1.BGDeploymentAvailable, corev1.ConditionFalse, minimumReplicasUnavailable, "BGDeployment does not have minimum availability."))
+}
+
+// setDegraded sets the Degraded condition of a BGDeployment from the outcome
+// of its last sync
+func setDegraded(status *demov1.BGDeploymentStatus, syncErr error) {
+	switch syncErr.(type) {
+	case nil:
+		setCondition(status, newCondition(demov1.BGDeploymentDegraded, corev1.ConditionFalse, syncSucceededReason, ""))
+	case *rolloutError:
+		setCondition(status, newCondition(demov1.BGDeploymentDegraded, corev1.ConditionTrue, rolloutFailedReason, syncErr.Error()))
+	default:
+		setCondition(status, newCondition(demov1.BGDeploymentDegraded, corev1.ConditionTrue, syncFailedReason, syncErr.Error()))
+	}
+}
@@ -0,0 +1,1198 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	demov1 "k8s.io/bgd-operator/pkg/apis/demo/v1"
+	clientset "k8s.io/bgd-operator/pkg/client/clientset/versioned"
+	bgdscheme "k8s.io/bgd-operator/pkg/client/clientset/versioned/scheme"
+	informers "k8s.io/bgd-operator/pkg/client/informers/externalversions/demo/v1"
+	listers "k8s.io/bgd-operator/pkg/client/listers/demo/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// maxRetries is the number of times a BGDeployment will be retried before it
+// is dropped out of the queue. With the current rate limiter in use
+// (5ms*2^(maxRetries-1)) the following numbers represent the sequence of
+// delays between successive queuings of a BGDeployment.
+//
+// 5ms, 10ms, 20ms, 40ms, 80ms, 160ms, 320ms, 640ms, 1.3s, 2.6s, 5.1s, 10.2s,
+// 20.4s, 41s, 82s
+const maxRetries = 15
+
+// controllerAgentName is the component the events of the operator come from
+const controllerAgentName = "bgd-operator"
+
+// Reasons of the events recorded on BGDeployments
+const (
+	replicaSetCreatedEvent  = "ReplicaSetCreated"
+	replicaSetReadyEvent    = "ReplicaSetReady"
+	replicaSetTimedOutEvent = "ProgressDeadlineExceeded"
+	trafficSwitchedEvent    = "TrafficSwitched"
+	scaledDownOldEvent      = "ScaledDownOld"
+	rollbackEvent           = "RollbackStarted"
+	migratedEvent           = "Migrated"
+	syncFailedEvent         = "SyncFailed"
+)
+
+var colorMap = map[string]string{"blue": "green", "green": "blue"}
+
+// Controller is the controller implementation for BGDeployment resources
+type Controller struct {
+	kubeclient   *kubeclient
+	bgdclientset clientset.Interface
+
+	// bgdLister lists the BGDeployments of all namespaces, including the
+	// ones that are not managed, so that they can still be finalized
+	bgdLister listers.BGDeploymentLister
+	// namespaces holds the managed namespaces, or is empty when all
+	// namespaces are managed
+	namespaces sets.String
+	// nsLister is only set when the managed namespaces are picked by a label
+	// selector, and only lists the namespaces matching it
+	nsLister corelisters.NamespaceLister
+	// rsListers and svcListers list the replicasets and services of each
+	// managed namespace, or of all namespaces under metav1.NamespaceAll when
+	// all of them are managed
+	rsListers   map[string]appslisters.ReplicaSetLister
+	svcListers  map[string]corelisters.ServiceLister
+	cacheSynced []cache.InformerSynced
+
+	// expectations holds the sync of a BGDeployment until its own writes to
+	// its replicasets and services show up in rsListers and svcListers
+	expectations *expectations
+
+	// recorder is an event recorder for recording Event resources to the
+	// Kubernetes API.
+	recorder record.EventRecorder
+
+	// workqueue is a rate limited work queue. This is used to queue work to be
+	// processed instead of performing it as soon as a change happens. This
+	// means we can ensure we only process a fixed amount of resources at a
+	// time, and makes it easy to ensure we are never processing the same item
+	// simultaneously in two different workers.
+	workqueue workqueue.RateLimitingInterface
+
+	// lastProgress is the time, in Unix nanoseconds, a worker last finished
+	// syncing a BGDeployment, or when the workers started. It is 0 until
+	// then. Only accessed atomically.
+	lastProgress int64
+}
+
+// NewController returns a new BGDeployment controller. bgdInformer watches the
+// BGDeployments of all namespaces. kubeInformers watch the replicasets and
+// services of the managed namespaces: they hold an informer factory per
+// managed namespace, or a single one under metav1.NamespaceAll when all
+// namespaces are managed. nsInformer is optional; when given, only the
+// BGDeployments of the namespaces it lists are managed. BGDeployments outside
+// of the managed namespaces are still finalized when they are deleted.
+func NewController(kubeclient *kubeclient, bgdclientset clientset.Interface, bgdInformer informers.BGDeploymentInformer, kubeInformers map[string]kubeinformers.SharedInformerFactory, nsInformer coreinformers.NamespaceInformer) *Controller {
+	// Create event broadcaster
+	// Add the types of the BGDeployment clientset to the default Kubernetes
+	// Scheme so Events can be logged for BGDeployments.
+	bgdscheme.AddToScheme(scheme.Scheme)
+	glog.V(4).Info("Creating event broadcaster")
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(glog.Infof)
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeclient.c.CoreV1().Events("")})
+	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: controllerAgentName})
+
+	controller := &Controller{
+		kubeclient:   kubeclient,
+		bgdclientset: bgdclientset,
+		bgdLister:    bgdInformer.Lister(),
+		namespaces:   sets.NewString(),
+		rsListers:    map[string]appslisters.ReplicaSetLister{},
+		svcListers:   map[string]corelisters.ServiceLister{},
+		cacheSynced:  []cache.InformerSynced{bgdInformer.Informer().HasSynced},
+		expectations: newExpectations(),
+		recorder:     recorder,
+		workqueue:    workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "BGDeployments"),
+	}
+	registerQueueMetrics(controller.workqueue)
+
+	glog.Info("Setting up event handlers")
+	// Set up an event handler for when BGDeployment resources change. Every
+	// event, including the periodic resync, only enqueues the key of the
+	// BGDeployment; Reconcile works out what has to be done from there.
+	bgdInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.enqueueBGDeployment,
+		UpdateFunc: func(old, new interface{}) {
+			controller.enqueueBGDeployment(new)
+		},
+		DeleteFunc: controller.enqueueBGDeployment,
+	})
+
+	// A namespace starting to match the namespace selector brings its
+	// BGDeployments under management
+	if nsInformer != nil {
+		controller.nsLister = nsInformer.Lister()
+		controller.cacheSynced = append(controller.cacheSynced, nsInformer.Informer().HasSynced)
+		nsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: controller.enqueueNamespace,
+		})
+	}
+
+	// Set up an event handler for when replicaset and service resources
+	// change. This handler will lookup the BGDeployment they belong to and
+	// enqueue it, so that e.g. a new RS becoming available moves its rollout
+	// forward, and a RS deleted by hand is brought back right away.
+	objectHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handleObject,
+		UpdateFunc: func(old, new interface{}) {
+			if old.(metav1.Object).GetResourceVersion() == new.(metav1.Object).GetResourceVersion() {
+				// Periodic resync will send update events for all known
+				// objects. Two different versions of the same object will
+				// always have different RVs.
+				return
+			}
+			controller.handleObject(old)
+			controller.handleObject(new)
+		},
+		DeleteFunc: controller.handleObject,
+	}
+	for namespace, factory := range kubeInformers {
+		if namespace != metav1.NamespaceAll {
+			controller.namespaces.Insert(namespace)
+		}
+		rsInformer := factory.Apps().V1().ReplicaSets()
+		svcInformer := factory.Core().V1().Services()
+		controller.rsListers[namespace] = rsInformer.Lister()
+		controller.svcListers[namespace] = svcInformer.Lister()
+		controller.cacheSynced = append(controller.cacheSynced, rsInformer.Informer().HasSynced, svcInformer.Informer().HasSynced)
+		rsInformer.Informer().AddEventHandler(objectHandler)
+		svcInformer.Informer().AddEventHandler(objectHandler)
+	}
+
+	return controller
+}
+
+// Run will set up the event handlers for types we are interested in, as well
+// as syncing informer caches and starting workers. It will block until stopCh
+// is closed, at which point it will shutdown the workqueue and wait for
+// workers to finish processing their current work items.
+func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
+	defer utilruntime.HandleCrash()
+	defer c.workqueue.ShutDown()
+
+	glog.Info("Starting BGDeployment controller")
+
+	// Wait for the caches to be synced before starting workers
+	glog.Info("Waiting for informer caches to sync")
+	if ok := cache.WaitForCacheSync(stopCh, c.cacheSynced...); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	glog.Info("Starting workers")
+	atomic.StoreInt64(&c.lastProgress, time.Now().UnixNano())
+	var wg sync.WaitGroup
+	for i := 0; i < threadiness; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait.Until(func() { c.runWorker(stopCh) }, time.Second, stopCh)
+		}()
+	}
+
+	glog.Info("Started workers")
+	<-stopCh
+
+	// Let the workers finish the BGDeployment they are syncing, which records
+	// the step its rollout is at in its status. The ones still waiting in the
+	// workqueue are picked up again by the next leader.
+	glog.Info("Shutting down workers")
+	c.workqueue.ShutDown()
+	wg.Wait()
+	glog.Info("Workers finished")
+
+	return nil
+}
+
+// runWorker is a long-running function that will continually call the
+// processNextWorkItem function in order to read and process a message on the
+// workqueue, until stopCh is closed.
+func (c *Controller) runWorker(stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+		if !c.processNextWorkItem() {
+			return
+		}
+	}
+}
+
+// processNextWorkItem will read a single work item off the workqueue and
+// attempt to process it, by calling Reconcile.
+func (c *Controller) processNextWorkItem() bool {
+	obj, shutdown := c.workqueue.Get()
+	if shutdown {
+		return false
+	}
+
+	// We call Done here so the workqueue knows we have finished processing
+	// this item.
+	defer c.workqueue.Done(obj)
+	key, ok := obj.(string)
+	if !ok {
+		// As the item in the workqueue is actually invalid, we call Forget
+		// here else we'd go into a loop of attempting to process a work item
+		// that is invalid.
+		c.workqueue.Forget(obj)
+		utilruntime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
+		return true
+	}
+
+	start := time.Now()
+	err := c.Reconcile(key)
+	reconcileDuration.Observe(time.Since(start).Seconds())
+	atomic.StoreInt64(&c.lastProgress, time.Now().UnixNano())
+	c.handleErr(err, key)
+	return true
+}
+
+// handleErr forgets the key when it was synced successfully, and otherwise
+// puts it back on the workqueue with an exponential per-key backoff, until
+// maxRetries is reached.
+func (c *Controller) handleErr(err error, key string) {
+	if err == nil {
+		// If no error occurs we Forget this item so it does not get queued
+		// again until another change happens.
+		c.workqueue.Forget(key)
+		glog.V(4).Infof("Successfully synced %q", key)
+		return
+	}
+
+	if c.workqueue.NumRequeues(key) < maxRetries {
+		glog.V(2).Infof("Error syncing BGDeployment %q, retrying: %v", key, err)
+		workqueueRetries.Inc()
+		c.workqueue.AddRateLimited(key)
+		return
+	}
+
+	// The next resync of the BGDeployment picks it up again
+	utilruntime.HandleError(fmt.Errorf("dropping BGDeployment %q out of the queue: %v", key, err))
+	workqueueDropped.Inc()
+	c.workqueue.Forget(key)
+}
+
+// enqueueBGDeployment takes a BGDeployment resource and converts it into a
+// namespace/name string which is then put onto the work queue. This method
+// should *not* be passed resources of any type other than BGDeployment.
+func (c *Controller) enqueueBGDeployment(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.workqueue.Add(key)
+}
+
+// handleObject will take any resource implementing metav1.Object and attempt
+// to find the BGDeployment resource that 'owns' it. It does this by looking at
+// the objects controller reference, or else at its bgdLabel, so that the
+// replicasets and services released by a BGDeployment also bring it back. It
+// then enqueues that BGDeployment resource to be processed. If the object has
+// neither, it will simply be skipped.
+func (c *Controller) handleObject(obj interface{}) {
+	object, ok := obj.(metav1.Object)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding object, invalid type"))
+			return
+		}
+		object, ok = tombstone.Obj.(metav1.Object)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding object tombstone, invalid type"))
+			return
+		}
+		glog.V(4).Infof("Recovered deleted object %q from tombstone", object.GetName())
+	}
+	name := object.GetLabels()[bgdLabel]
+	if ownerRef := metav1.GetControllerOf(object); ownerRef != nil {
+		if ownerRef.Kind != controllerKind.Kind || ownerRef.APIVersion != controllerKind.GroupVersion().String() {
+			return
+		}
+		name = ownerRef.Name
+	}
+	if name == "" {
+		return
+	}
+	c.workqueue.Add(object.GetNamespace() + "/" + name)
+}
+
+// bgdKey returns the key of the BGDeployment in the work queue
+func bgdKey(bgd *demov1.BGDeployment) string {
+	return bgd.Namespace + "/" + bgd.Name
+}
+
+// enqueueNamespace enqueues all the BGDeployments of a namespace
+func (c *Controller) enqueueNamespace(obj interface{}) {
+	ns, ok := obj.(*corev1.Namespace)
+	if !ok {
+		utilruntime.HandleError(fmt.Errorf("expected a namespace but got %#v", obj))
+		return
+	}
+	if !c.managesNamespace(ns.Name) {
+		return
+	}
+	bgds, err := c.bgdLister.BGDeployments(ns.Name).List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, bgd := range bgds {
+		c.enqueueBGDeployment(bgd)
+	}
+}
+
+// managesNamespace returns whether the BGDeployments of the namespace are
+// managed
+func (c *Controller) managesNamespace(namespace string) bool {
+	if c.namespaces.Len() > 0 && !c.namespaces.Has(namespace) {
+		return false
+	}
+	if c.nsLister != nil {
+		if _, err := c.nsLister.Get(namespace); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// replicaSets returns the lister of the replicasets of a managed namespace
+func (c *Controller) replicaSets(namespace string) appslisters.ReplicaSetNamespaceLister {
+	if lister, ok := c.rsListers[namespace]; ok {
+		return lister.ReplicaSets(namespace)
+	}
+	return c.rsListers[metav1.NamespaceAll].ReplicaSets(namespace)
+}
+
+// services returns the lister of the services of a managed namespace
+func (c *Controller) services(namespace string) corelisters.ServiceNamespaceLister {
+	if lister, ok := c.svcListers[namespace]; ok {
+		return lister.Services(namespace)
+	}
+	return c.svcListers[metav1.NamespaceAll].Services(namespace)
+}
+
+// enqueueBGDeploymentAfter puts the BGDeployment back onto the work queue
+// after the given duration
+func (c *Controller) enqueueBGDeploymentAfter(bgd *demov1.BGDeployment, after time.Duration) {
+	key, err := cache.MetaNamespaceKeyFunc(bgd)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.workqueue.AddAfter(key, after)
+}
+
+// Reconcile compares the actual state of the BGDeployment identified by key
+// with its desired state, and attempts to converge the two. It is
+// level-triggered: the active color, the current template and the replicasets
+// of the BGDeployment are all read back from the cluster, so it can be called
+// any number of times for the same key, including after a restart.
+//
+// The outcome of the sync is recorded in the status of the BGDeployment. Any
+// error returned means the key has to be retried.
+func (c *Controller) Reconcile(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("invalid resource key: %s", key))
+		return nil
+	}
+
+	bgd, err := c.bgdLister.BGDeployments(namespace).Get(name)
+	if err != nil {
+		// The BGDeployment resource may no longer exist, in which case its
+		// finalizer has already torn down what it created
+		if apierrors.IsNotFound(err) {
+			glog.V(4).Infof("BGDeployment %q has been deleted", key)
+			c.expectations.forget(key)
+			return nil
+		}
+		return err
+	}
+
+	// A BGDeployment being deleted is finalized even when its namespace is
+	// not managed, e.g. when it stopped matching the namespace selector, as
+	// nothing else would remove its finalizer
+	if bgd.DeletionTimestamp != nil {
+		return c.finalize(bgd)
+	}
+	if !c.managesNamespace(namespace) {
+		// Its BGDeployments are left as they are
+		glog.V(4).Infof("skipping BGDeployment %q of a namespace that is not managed", key)
+		return nil
+	}
+	if !hasFinalizer(bgd) {
+		// The update of the BGDeployment brings it back to the queue
+		return c.addFinalizer(bgd)
+	}
+	if !c.expectations.satisfied(key) {
+		// The informer event of the pending write brings the BGDeployment
+		// back to the queue
+		glog.V(4).Infof("waiting for the writes of BGDeployment %q to be observed", key)
+		return nil
+	}
+
+	status := bgd.Status.DeepCopy()
+	status.ObservedGeneration = bgd.Generation
+	syncErr := c.syncBGDeployment(bgd, status)
+	if syncErr != nil {
+		c.recorder.Event(bgd, corev1.EventTypeWarning, syncFailedEvent, syncErr.Error())
+	}
+	if err := c.updateStatus(bgd, status, syncErr); err != nil {
+		return err
+	}
+	if _, ok := syncErr.(*rolloutError); ok {
+		// Retrying does not help, the next change of the spec does
+		return nil
+	}
+	return syncErr
+}
+
+// syncBGDeployment makes sure the BGDeployment has a service pointing to a
+// replicaset that runs the pod template of its spec, with the desired number
+// of replicas. What it observes along the way is filled into status.
+func (c *Controller) syncBGDeployment(bgd *demov1.BGDeployment, status *demov1.BGDeploymentStatus) error {
+	rss, err := c.ownedReplicaSets(bgd)
+	if err != nil {
+		return err
+	}
+	legacyRSs, err := c.legacyReplicaSets(bgd)
+	if err != nil {
+		return err
+	}
+	if len(legacyRSs) > 0 {
+		// Created by an earlier version of the operator
+		return c.migrate(bgd, status, legacyRSs, rss)
+	}
+	if len(rss) == 0 {
+		// Create a blue RS along with CRD creation
+		rs, err := c.createReplicaSet("blue", bgd)
+		if err != nil {
+			return err
+		}
+		rss = append(rss, rs)
+	}
+
+	svc, err := c.ensureService(bgd, rss)
+	if err != nil {
+		return err
+	}
+	activeColor := svc.Spec.Selector[colorLabel]
+	if err = c.ensurePreviewService(bgd, colorMap[activeColor]); err != nil {
+		return err
+	}
+	if err = c.deleteStaleServices(bgd); err != nil {
+		return err
+	}
+
+	activeRS := replicaSetForColor(rss, activeColor)
+	if activeRS == nil {
+		// The RS serving traffic is gone, bring it back with the current template
+		_, err = c.createReplicaSet(activeColor, bgd)
+		return err
+	}
+
+	// The RS serving traffic may never have been switched over to, e.g. the
+	// first RS of the BGDeployment
+	if _, ok := activeRS.Annotations[activeSinceAnnotation]; !ok {
+		if err = c.markReplicaSetActive(bgd, activeRS, metav1.Now()); err != nil {
+			return fmt.Errorf("failed to mark RS %q as active: %v", activeRS.Name, err)
+		}
+	}
+
+	// The scale subresource reports the pods of the color serving traffic
+	status.Replicas = activeRS.Status.Replicas
+	status.Selector = labels.SelectorFromSet(bgdLabels(bgd, activeColor)).String()
+
+	inactiveRS := replicaSetForColor(rss, colorMap[activeColor])
+	status.ActiveColor, status.PreviewColor = activeColor, colorMap[activeColor]
+	setActiveColor(bgd, activeColor)
+	status.ActiveReplicaSet = replicaSetStatus(activeRS)
+	status.PreviewReplicaSet = replicaSetStatus(inactiveRS)
+	setAvailable(status, activeRS)
+	if bgd.Spec.RollbackTo != nil {
+		return c.rollback(bgd, status, activeRS, inactiveRS)
+	}
+
+	// Only roll out a new RS when the pod template is changed
+	if activeRS.Labels[templateHashLabel] != computeHash(podTemplate(bgd)) {
+		return c.rollout(bgd, status, svc, activeRS, inactiveRS)
+	}
+
+	// The service has been switched over, or the template was changed back
+	// in the middle of a rollout; either way, the inactive RS has to go
+	if inactiveRS != nil && *inactiveRS.Spec.Replicas != 0 {
+		return c.scaleDownOld(bgd, status, inactiveRS)
+	}
+
+	status.Phase = demov1.BGDeploymentCompleted
+	setProgressing(status, newRSAvailableReason, activeRS)
+
+	// Apply the desired number of replicas to the color serving traffic
+	if replicas := bgdReplicas(bgd); *activeRS.Spec.Replicas != replicas {
+		if err = c.scaleReplicaSet(bgd, activeRS, replicas); err != nil {
+			return fmt.Errorf("failed to scale RS %q to %d replicas: %v", activeRS.Name, replicas, err)
+		}
+	}
+	return nil
+}
+
+// updateStatus records status and the outcome of the last sync on the
+// BGDeployment
+func (c *Controller) updateStatus(bgd *demov1.BGDeployment, status *demov1.BGDeploymentStatus, syncErr error) error {
+	status.Message = ""
+	if syncErr != nil {
+		status.Phase, status.Message = demov1.BGDeploymentFailed, syncErr.Error()
+	}
+	setDegraded(status, syncErr)
+	if equality.Semantic.DeepEqual(bgd.Status, *status) {
+		return nil
+	}
+
+	// The status is written through the status subresource, which ignores
+	// any change to the spec. It only conflicts with another write of the
+	// status, or with a change of the spec made by the sync itself, in which
+	// case it is written to the latest version of the BGDeployment.
+	bgdClient := c.bgdclientset.DemoV1().BGDeployments(bgd.Namespace)
+	// NEVER modify objects from the store. It's a read-only, local cache.
+	bgdCopy := bgd.DeepCopy()
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		bgdCopy.Status = *status
+		_, err := bgdClient.UpdateStatus(bgdCopy)
+		if !apierrors.IsConflict(err) {
+			return err
+		}
+		latest, getErr := bgdClient.Get(bgd.Name, metav1.GetOptions{})
+		if getErr != nil {
+			return getErr
+		}
+		bgdCopy = latest
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update status of BGDeployment %q: %v", bgd.Name, err)
+	}
+	return nil
+}
+
+// ownedReplicaSets returns the replicasets controlled by the BGDeployment.
+// Only replicasets created for a BGDeployment are looked at, and of those only
+// the ones the BGDeployment controls, or could adopt, are returned; scaling
+// and deleting is never done on anything else.
+func (c *Controller) ownedReplicaSets(bgd *demov1.BGDeployment) ([]*appsv1.ReplicaSet, error) {
+	// List the replicasets of every BGDeployment in the namespace, so that
+	// the ones relabelled to another BGDeployment get released. The ones
+	// created by earlier versions of the operator carry no bgdLabel, and
+	// are replaced by migrate instead.
+	rss, err := c.replicaSets(bgd.Namespace).List(managedSelector())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list RSs: %v", err)
+	}
+	return c.claimReplicaSets(bgd, bgdSelector(bgd), rss)
+}
+
+// createReplicaSet creates a RS of the given color running the template of the
+// BGDeployment
+func (c *Controller) createReplicaSet(color string, bgd *demov1.BGDeployment) (*appsv1.ReplicaSet, error) {
+	rs, err := c.kubeclient.CreateReplicaSet(color, bgd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s RS: %v", color, err)
+	}
+	glog.Infof("created replicaset %q", rs.Name)
+	c.expectReplicaSet(bgd, rs.Name, func(rs *appsv1.ReplicaSet) bool {
+		return rs != nil
+	})
+	c.recorder.Eventf(bgd, corev1.EventTypeNormal, replicaSetCreatedEvent, "Created %s RS %q", color, rs.Name)
+	return rs, nil
+}
+
+// deleteReplicaSet deletes the RS of the BGDeployment
+func (c *Controller) deleteReplicaSet(bgd *demov1.BGDeployment, rs *appsv1.ReplicaSet) error {
+	if err := c.kubeclient.DeleteReplicaSet(rs); err != nil {
+		return err
+	}
+	c.expectReplicaSet(bgd, rs.Name, func(rs *appsv1.ReplicaSet) bool {
+		return rs == nil
+	})
+	return nil
+}
+
+// scaleReplicaSet scales the RS of the BGDeployment to the given number of
+// replicas
+func (c *Controller) scaleReplicaSet(bgd *demov1.BGDeployment, rs *appsv1.ReplicaSet, replicas int32) error {
+	if err := c.kubeclient.ScaleReplicaSet(rs, replicas); err != nil {
+		return err
+	}
+	c.expectReplicaSet(bgd, rs.Name, func(rs *appsv1.ReplicaSet) bool {
+		return rs == nil || *rs.Spec.Replicas == replicas
+	})
+	return nil
+}
+
+// restoreReplicaSet labels the RS of the BGDeployment with the hash of the
+// template it is rolled back to, and scales it to the given number of replicas
+func (c *Controller) restoreReplicaSet(bgd *demov1.BGDeployment, rs *appsv1.ReplicaSet, hash string, replicas int32) error {
+	if err := c.kubeclient.RestoreReplicaSet(rs, hash, replicas); err != nil {
+		return err
+	}
+	c.expectReplicaSet(bgd, rs.Name, func(rs *appsv1.ReplicaSet) bool {
+		return rs == nil || (rs.Labels[templateHashLabel] == hash && *rs.Spec.Replicas == replicas)
+	})
+	return nil
+}
+
+// markReplicaSetActive records on the RS of the BGDeployment that the service
+// sends traffic to it
+func (c *Controller) markReplicaSetActive(bgd *demov1.BGDeployment, rs *appsv1.ReplicaSet, since metav1.Time) error {
+	if err := c.kubeclient.MarkReplicaSetActive(rs, since); err != nil {
+		return err
+	}
+	c.expectReplicaSet(bgd, rs.Name, func(rs *appsv1.ReplicaSet) bool {
+		return rs == nil || rs.Annotations[activeSinceAnnotation] != ""
+	})
+	return nil
+}
+
+// createService creates a service of the BGDeployment
+func (c *Controller) createService(bgd *demov1.BGDeployment, svc *corev1.Service) (*corev1.Service, error) {
+	svc, err := c.kubeclient.CreateService(svc)
+	if err != nil {
+		return nil, err
+	}
+	c.expectService(bgd, svc.Name, func(svc *corev1.Service) bool {
+		return svc != nil
+	})
+	return svc, nil
+}
+
+// deleteService deletes a service of the BGDeployment
+func (c *Controller) deleteService(bgd *demov1.BGDeployment, svc *corev1.Service) error {
+	if err := c.kubeclient.DeleteService(svc.Name, svc.Namespace); err != nil {
+		return err
+	}
+	c.expectService(bgd, svc.Name, func(svc *corev1.Service) bool {
+		return svc == nil
+	})
+	return nil
+}
+
+// expectReplicaSet holds the next sync of the BGDeployment until the RS
+// informer observes the RS with the given name in a state for which observed
+// returns true. observed is given nil while the RS does not exist.
+func (c *Controller) expectReplicaSet(bgd *demov1.BGDeployment, name string, observed func(rs *appsv1.ReplicaSet) bool) {
+	lister := c.replicaSets(bgd.Namespace)
+	c.expectations.expect(bgdKey(bgd), func() bool {
+		rs, err := lister.Get(name)
+		if err != nil {
+			rs = nil
+		}
+		return observed(rs)
+	})
+}
+
+// expectService holds the next sync of the BGDeployment until the service
+// informer observes the service with the given name in a state for which
+// observed returns true. observed is given nil while the service does not
+// exist.
+func (c *Controller) expectService(bgd *demov1.BGDeployment, name string, observed func(svc *corev1.Service) bool) {
+	lister := c.services(bgd.Namespace)
+	c.expectations.expect(bgdKey(bgd), func() bool {
+		svc, err := lister.Get(name)
+		if err != nil {
+			svc = nil
+		}
+		return observed(svc)
+	})
+}
+
+// ensureService returns the service of the BGDeployment, creating it if it
+// does not exist yet, and otherwise correcting any drift from the spec of the
+// BGDeployment. A new service selects the color picked by serviceColor.
+func (c *Controller) ensureService(bgd *demov1.BGDeployment, rss []*appsv1.ReplicaSet) (*corev1.Service, error) {
+	svc, err := c.services(bgd.Namespace).Get(serviceName(bgd))
+	if apierrors.IsNotFound(err) {
+		var color string
+		color, err = c.serviceColor(bgd, rss)
+		if err != nil {
+			return nil, err
+		}
+		svc, err = c.createService(bgd, newService(bgd, color))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create service: %v", err)
+		}
+		return svc, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service: %v", err)
+	}
+
+	// Correct any drift, leaving the color the service points to alone
+	return c.syncService(bgd, svc, newService(bgd, svc.Spec.Selector[colorLabel]))
+}
+
+// ensurePreviewService makes sure the preview service of the BGDeployment,
+// if it has one, exists and sends traffic to the given color.
+func (c *Controller) ensurePreviewService(bgd *demov1.BGDeployment, color string) error {
+	if bgd.Spec.PreviewService == nil {
+		return nil
+	}
+	desired := newPreviewService(bgd, color)
+	svc, err := c.services(bgd.Namespace).Get(desired.Name)
+	if apierrors.IsNotFound(err) {
+		if _, err = c.createService(bgd, desired); err != nil {
+			return fmt.Errorf("failed to create preview service: %v", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get preview service: %v", err)
+	}
+	_, err = c.syncService(bgd, svc, desired)
+	return err
+}
+
+// syncService updates svc to match desired, if they differ in any of the
+// fields the operator manages.
+func (c *Controller) syncService(bgd *demov1.BGDeployment, svc, desired *corev1.Service) (*corev1.Service, error) {
+	controllerRef := metav1.GetControllerOf(svc)
+	if svc.Labels[bgdLabel] != bgd.Name || (controllerRef != nil && controllerRef.UID != bgd.UID) {
+		return nil, fmt.Errorf("service %q already exists and does not belong to the BGDeployment", svc.Name)
+	}
+	updated := svc.DeepCopy()
+	applyService(updated, desired)
+	if equality.Semantic.DeepEqual(svc, updated) {
+		return svc, nil
+	}
+	patch, err := servicePatch(svc, updated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute the patch of service %q: %v", desired.Name, err)
+	}
+	svc, err = c.kubeclient.PatchService(svc.Name, svc.Namespace, patch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update service %q: %v", desired.Name, err)
+	}
+	return svc, nil
+}
+
+// serviceColor returns the color a new service of the BGDeployment sends
+// traffic to: the color of the service it replaces, when the name of the
+// service changed, or else the color of the RS that started serving traffic
+// last. It is blue for a new BGDeployment. The number of replicas of a RS
+// tells nothing, as both colors have replicas during a rollout.
+func (c *Controller) serviceColor(bgd *demov1.BGDeployment, rss []*appsv1.ReplicaSet) (string, error) {
+	svcs, err := c.services(bgd.Namespace).List(bgdSelector(bgd))
+	if err != nil {
+		return "", fmt.Errorf("failed to list services: %v", err)
+	}
+	for _, svc := range svcs {
+		// The preview service points to the other color
+		if svc.Name == previewServiceName(bgd) || !metav1.IsControlledBy(svc, bgd) {
+			continue
+		}
+		if color := svc.Spec.Selector[colorLabel]; colorMap[color] != "" {
+			return color, nil
+		}
+	}
+
+	color, since := "blue", ""
+	for _, rs := range rss {
+		// RFC 3339 times in UTC sort as strings
+		if t := rs.Annotations[activeSinceAnnotation]; t > since {
+			color, since = replicaSetColor(rs), t
+		}
+	}
+	return color, nil
+}
+
+// deleteStaleServices deletes the services of the BGDeployment left behind by
+// a change of the service name, or by removing the preview service. Only the
+// services the BGDeployment controls are deleted; the ones released by a
+// BGDeployment of the same name with the Orphan retention policy, or
+// controlled by another object, are left alone.
+func (c *Controller) deleteStaleServices(bgd *demov1.BGDeployment) error {
+	names := sets.NewString(serviceName(bgd))
+	if bgd.Spec.PreviewService != nil {
+		names.Insert(previewServiceName(bgd))
+	}
+	svcs, err := c.services(bgd.Namespace).List(bgdSelector(bgd))
+	if err != nil {
+		return fmt.Errorf("failed to list services: %v", err)
+	}
+	for _, svc := range svcs {
+		if names.Has(svc.Name) || !metav1.IsControlledBy(svc, bgd) {
+			continue
+		}
+		err = c.deleteService(bgd, svc)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete service %q: %v", svc.Name, err)
+		}
+	}
+	return nil
+}
+
+// rolloutError is returned when a rollout or a rollback can't make progress
+// until the spec of the BGDeployment changes again, e.g. when the pods of a new
+// RS did not become available in time. Retrying does not help.
+type rolloutError struct {
+	message string
+}
+
+func (e *rolloutError) Error() string {
+	return e.message
+}
+
+// notAvailableError returns the rolloutError of a RS whose pods did not become
+// available in time
+func notAvailableError(rs *appsv1.ReplicaSet) error {
+	return &rolloutError{message: fmt.Sprintf("pods of RS %q did not become available", rs.Name)}
+}
+
+// rollout advances the rollout of the pod template of the BGDeployment to a RS
+// of the inactive color by one step, and records the phase it reached in
+// status. A step only relies on what it reads back from the cluster and on the
+// phase recorded by the previous step, so a rollout interrupted by a restart
+// of the operator resumes where it stopped. Recording the new phase updates
+// the BGDeployment, which brings it back to the queue for the next step.
+func (c *Controller) rollout(bgd *demov1.BGDeployment, status *demov1.BGDeploymentStatus, svc *corev1.Service, activeRS, inactiveRS *appsv1.ReplicaSet) error {
+	if inactiveRS == nil || inactiveRS.Labels[templateHashLabel] != computeHash(podTemplate(bgd)) {
+		return c.provision(bgd, status, activeRS, inactiveRS)
+	}
+	newRS := inactiveRS
+
+	// A previous rollout of the same template did not become available in
+	// time and was scaled down; wait for the next change of the template. A
+	// RS scaled to 0 replicas is otherwise rolled out like any other.
+	if rolloutTimedOut(*status, newRS) {
+		return notAvailableError(newRS)
+	}
+
+	if status.Phase == demov1.BGDeploymentPromoting {
+		return c.promote(bgd, status, svc, newRS)
+	}
+	return c.waitForReady(bgd, status, newRS)
+}
+
+// provision makes way for the new RS by deleting the RS of the inactive color,
+// which runs an older template, or creates the new RS once there is none
+func (c *Controller) provision(bgd *demov1.BGDeployment, status *demov1.BGDeploymentStatus, activeRS, inactiveRS *appsv1.ReplicaSet) error {
+	status.Phase = demov1.BGDeploymentProvisioning
+	if inactiveRS != nil {
+		// Delete the inactive RS to give way to the new RS
+		err := c.deleteReplicaSet(bgd, inactiveRS)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete RS %q before creating a new RS with newest template: %v", inactiveRS.Name, err)
+		}
+		// The RS informer brings the BGDeployment back for the next step
+		// once the RS is gone
+		return nil
+	}
+
+	// Create a new RS with the new color
+	newRS, err := c.createReplicaSet(colorMap[replicaSetColor(activeRS)], bgd)
+	if err != nil {
+		return err
+	}
+	setProgressing(status, newRSCreatedReason, newRS)
+	rolloutsStarted.WithLabelValues(bgd.Namespace, bgd.Name).Inc()
+	status.Phase = demov1.BGDeploymentWaitingForReady
+	return nil
+}
+
+// waitForReady checks whether all pods of the new RS are available (i.e.,
+// ready for minReadySeconds). While they are not, the BGDeployment is checked
+// again as they change rather than holding up a worker, until the progress
+// deadline of the rollout has passed. Once they are, the rollout is promoted,
+// or held until it is.
+func (c *Controller) waitForReady(bgd *demov1.BGDeployment, status *demov1.BGDeploymentStatus, newRS *appsv1.ReplicaSet) error {
+	// The desired number of replicas may have changed in the meantime
+	if replicas := bgdReplicas(bgd); *newRS.Spec.Replicas != replicas {
+		if err := c.scaleReplicaSet(bgd, newRS, replicas); err != nil {
+			return fmt.Errorf("failed to scale new RS %q to %d replicas: %v", newRS.Name, replicas, err)
+		}
+		// NEVER modify objects from the store. It's a read-only, local cache.
+		newRS = newRS.DeepCopy()
+		*newRS.Spec.Replicas = replicas
+	}
+
+	if !replicaSetAvailable(newRS) {
+		// The RS is picked up with no rollout on record, e.g. when its pods
+		// stopped being available while it was awaiting promotion
+		if rolloutInProgress(*status) == nil {
+			setProgressing(status, rsUpdatedReason, newRS)
+		}
+		remaining := progressDeadline(bgd) - time.Since(rolloutInProgress(*status).LastUpdateTime.Time)
+		if remaining <= 0 {
+			// Scale down the new RS to zero replica
+			if err := c.scaleReplicaSet(bgd, newRS, 0); err != nil {
+				return fmt.Errorf("failed to scale down new RS to zero replica: %v", err)
+			}
+			setProgressing(status, timedOutReason, newRS)
+			c.recorder.Eventf(bgd, corev1.EventTypeWarning, replicaSetTimedOutEvent, "Pods of RS %q did not become available within %v, scaled it down", newRS.Name, progressDeadline(bgd))
+			rolloutsFailed.WithLabelValues(bgd.Namespace, bgd.Name).Inc()
+			return notAvailableError(newRS)
+		}
+		// The RS informer brings the BGDeployment back as the pods of the
+		// new RS become available; check again once the progress deadline
+		// has passed, in case they never do
+		status.Phase = demov1.BGDeploymentWaitingForReady
+		c.enqueueBGDeploymentAfter(bgd, remaining)
+		return nil
+	}
+	// Only the sync that sees the pods become available records it; the
+	// Progressing condition is left as it is while the RS awaits promotion
+	if cond := rolloutInProgress(*status); cond != nil {
+		c.recorder.Eventf(bgd, corev1.EventTypeNormal, replicaSetReadyEvent, "Pods of RS %q are available", newRS.Name)
+		rolloutReadyDuration.WithLabelValues(bgd.Namespace, bgd.Name).Observe(time.Since(cond.LastUpdateTime.Time).Seconds())
+		setProgressing(status, newRSAvailableReason, newRS)
+	}
+
+	// Hold the new RS until the rollout is promoted. A rollback is promoted
+	// right away.
+	rollingBack := status.LastRollback != nil && status.LastRollback.ToReplicaSet == newRS.Name
+	if !autoPromote(bgd) && !promotionRequested(bgd, newRS) && !rollingBack {
+		glog.V(2).Infof("RS %q of BGDeployment %q is available, awaiting promotion", newRS.Name, bgd.Name)
+		status.Phase = demov1.BGDeploymentAwaitingPromotion
+		return nil
+	}
+	status.Phase = demov1.BGDeploymentPromoting
+	return nil
+}
+
+// promote points the service to the new RS. The preview service follows on
+// the next sync, which also scales down the old RS now that the service no
+// longer sends traffic to it.
+func (c *Controller) promote(bgd *demov1.BGDeployment, status *demov1.BGDeploymentStatus, svc *corev1.Service, newRS *appsv1.ReplicaSet) error {
+	// Only the color of the service changes, the rest of it is left alone
+	color := replicaSetColor(newRS)
+	patch := fmt.Sprintf(`{"metadata":{"labels":{%q:%q}},"spec":{"selector":{%q:%q}}}`, colorLabel, color, colorLabel, color)
+	_, err := c.kubeclient.PatchService(svc.Name, bgd.Namespace, []byte(patch))
+	if err != nil {
+		return fmt.Errorf("failed to update service to point to new RS, %q: %v", newRS.Name, err)
+	}
+	c.expectService(bgd, svc.Name, func(svc *corev1.Service) bool {
+		return svc == nil || svc.Spec.Selector[colorLabel] == color
+	})
+	c.recorder.Eventf(bgd, corev1.EventTypeNormal, trafficSwitchedEvent, "Switched service %q over to %s RS %q", svc.Name, color, newRS.Name)
+	rolloutsSucceeded.WithLabelValues(bgd.Namespace, bgd.Name).Inc()
+	rolloutSwitchDuration.WithLabelValues(bgd.Namespace, bgd.Name).Observe(time.Since(rolloutStartTime(status, newRS)).Seconds())
+	setActiveColor(bgd, color)
+	now := metav1.Now()
+	if err = c.markReplicaSetActive(bgd, newRS, now); err != nil {
+		return fmt.Errorf("failed to mark RS %q as active: %v", newRS.Name, err)
+	}
+	status.LastPromotionTime = &now
+	status.Phase = demov1.BGDeploymentScalingDownOld
+	return nil
+}
+
+// scaleDownOld scales the RS that served traffic before the last rollout down
+// to zero replica, which completes the rollout
+func (c *Controller) scaleDownOld(bgd *demov1.BGDeployment, status *demov1.BGDeploymentStatus, oldRS *appsv1.ReplicaSet) error {
+	status.Phase = demov1.BGDeploymentScalingDownOld
+	if err := c.scaleReplicaSet(bgd, oldRS, 0); err != nil {
+		return fmt.Errorf("failed to scale down old RS to zero replica: %v", err)
+	}
+	c.recorder.Eventf(bgd, corev1.EventTypeNormal, scaledDownOldEvent, "Scaled down %s RS %q to 0", replicaSetColor(oldRS), oldRS.Name)
+
+	// The promotion has been used up, the next rollout needs a new one
+	if _, ok := bgd.Annotations[demov1.PromoteAnnotation]; ok {
+		patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:null}}}`, demov1.PromoteAnnotation)
+		_, err := c.bgdclientset.DemoV1().BGDeployments(bgd.Namespace).Patch(bgd.Name, types.MergePatchType, []byte(patch))
+		if err != nil {
+			return fmt.Errorf("failed to remove the %s annotation: %v", demov1.PromoteAnnotation, err)
+		}
+	}
+	status.Phase = demov1.BGDeploymentCompleted
+	return nil
+}
+
+// rollback rolls the BGDeployment back to the RS that served traffic before
+// the last rollout. The template of that RS becomes the template of the
+// BGDeployment again, and the RS is scaled back up, to be promoted as soon as
+// its pods are available.
+//
+// While a rollout is in progress, or after it failed, the inactive RS is the
+// new RS of that rollout, not the previous one. The rollout is aborted
+// instead: the template of the active RS is written back, and the new RS is
+// scaled down, so that it never gets promoted.
+func (c *Controller) rollback(bgd *demov1.BGDeployment, status *demov1.BGDeploymentStatus, activeRS, inactiveRS *appsv1.ReplicaSet) error {
+	aborting := activeRS.Labels[templateHashLabel] != computeHash(podTemplate(bgd))
+	fromRS, toRS := activeRS, inactiveRS
+	if aborting {
+		fromRS, toRS = inactiveRS, activeRS
+	}
+
+	target := bgd.Spec.RollbackTo.TemplateHash
+	var rollbackErr error
+	switch {
+	case toRS == nil:
+		rollbackErr = &rolloutError{message: "unable to roll back: there is no previous RS"}
+	case toRS.Annotations[activeSinceAnnotation] == "" && !aborting:
+		rollbackErr = &rolloutError{message: fmt.Sprintf("unable to roll back: previous RS %q never served traffic", toRS.Name)}
+	case target != "" && toRS.Labels[templateHashLabel] != target:
+		rollbackErr = &rolloutError{message: fmt.Sprintf("unable to roll back: previous RS %q does not have template hash %q", toRS.Name, target)}
+	}
+
+	var template *corev1.PodTemplateSpec
+	if rollbackErr == nil {
+		template = replicaSetTemplate(toRS)
+
+		// The API server fills in defaults of the template of the RS, so the
+		// RS is labelled with the hash of the template as it is written
+		// back to the BGDeployment
+		hash := computeHash(template)
+		replicas := bgdReplicas(bgd)
+		if toRS.Labels[templateHashLabel] != hash || *toRS.Spec.Replicas != replicas {
+			if err := c.restoreReplicaSet(bgd, toRS, hash, replicas); err != nil {
+				return fmt.Errorf("failed to scale up previous RS %q: %v", toRS.Name, err)
+			}
+		}
+		if aborting && fromRS != nil && *fromRS.Spec.Replicas != 0 {
+			if err := c.scaleReplicaSet(bgd, fromRS, 0); err != nil {
+				return fmt.Errorf("failed to scale down new RS %q to zero replica: %v", fromRS.Name, err)
+			}
+		}
+	}
+
+	// Write the template rolled back to to the BGDeployment, and clear
+	// rollbackTo now that the rollback is under way, or failed for good
+	bgdCopy := bgd.DeepCopy()
+	bgdCopy.Spec.RollbackTo = nil
+	if template != nil {
+		bgdCopy.Spec.Template, bgdCopy.Spec.Image = *template, ""
+	}
+	_, err := c.bgdclientset.DemoV1().BGDeployments(bgd.Namespace).Update(bgdCopy)
+	if err != nil {
+		return fmt.Errorf("failed to roll back the template of the BGDeployment: %v", err)
+	}
+	if rollbackErr != nil {
+		return rollbackErr
+	}
+
+	rollbacks.WithLabelValues(bgd.Namespace, bgd.Name).Inc()
+	status.LastRollback = &demov1.BGDeploymentRollbackStatus{
+		Time:         metav1.Now(),
+		ToReplicaSet: toRS.Name,
+	}
+	if fromRS != nil {
+		status.LastRollback.FromReplicaSet = fromRS.Name
+	}
+	if aborting {
+		glog.Infof("aborting the rollout of BGDeployment %q, RS %q keeps serving traffic", bgd.Name, activeRS.Name)
+		c.recorder.Eventf(bgd, corev1.EventTypeNormal, rollbackEvent, "Aborted the rollout, RS %q keeps serving traffic", activeRS.Name)
+		setProgressing(status, newRSAvailableReason, activeRS)
+		status.Phase = demov1.BGDeploymentCompleted
+		return nil
+	}
+
+	glog.Infof("rolling BGDeployment %q back from RS %q to RS %q", bgd.Name, activeRS.Name, toRS.Name)
+	c.recorder.Eventf(bgd, corev1.EventTypeNormal, rollbackEvent, "Rolling back from RS %q to RS %q", activeRS.Name, toRS.Name)
+	rolloutsStarted.WithLabelValues(bgd.Namespace, bgd.Name).Inc()
+	setProgressing(status, rsUpdatedReason, toRS)
+	status.Phase = demov1.BGDeploymentWaitingForReady
+	return nil
+}
+
+// replicaSetTemplate returns the pod template of the RS, without the labels
+// the operator adds
+func replicaSetTemplate(rs *appsv1.ReplicaSet) *corev1.PodTemplateSpec {
+	template := rs.Spec.Template.DeepCopy()
+	for _, k := range []string{bgdLabel, colorLabel, templateHashLabel} {
+		delete(template.Labels, k)
+	}
+	if len(template.Labels) == 0 {
+		template.Labels = nil
+	}
+	return template
+}
+
+// replicaSetStatus returns the status of a RS of a BGDeployment, or nil if
+// there is no RS
+func replicaSetStatus(rs *appsv1.ReplicaSet) *demov1.BGDeploymentReplicaSetStatus {
+	if rs == nil {
+		return nil
+	}
+	return &demov1.BGDeploymentReplicaSetStatus{
+		Name:              rs.Name,
+		TemplateHash:      rs.Labels[templateHashLabel],
+		Replicas:          *rs.Spec.Replicas,
+		ReadyReplicas:     rs.Status.ReadyReplicas,
+		AvailableReplicas: rs.Status.AvailableReplicas,
+	}
+}
+
+// autoPromote returns whether the service of the BGDeployment is switched over
+// to a new RS as soon as its pods are available
+func autoPromote(bgd *demov1.BGDeployment) bool {
+	return bgd.Spec.Strategy.AutoPromote == nil || *bgd.Spec.Strategy.AutoPromote
+}
+
+// promotionRequested returns whether the rollout of the new RS has been
+// promoted by hand. The promotion names the RS, so that it never approves
+// another rollout than the one it was given for.
+func promotionRequested(bgd *demov1.BGDeployment, newRS *appsv1.ReplicaSet) bool {
+	return bgd.Annotations[demov1.PromoteAnnotation] == newRS.Name
+}
+
+// replicaSetColor returns the color label a RS selects its pods by
+func replicaSetColor(rs *appsv1.ReplicaSet) string {
+	if rs.Spec.Selector == nil {
+		return ""
+	}
+	return rs.Spec.Selector.MatchLabels[colorLabel]
+}
+
+// replicaSetForColor returns the RS of the given color, or nil if there is none
+func replicaSetForColor(rss []*appsv1.ReplicaSet, color string) *appsv1.ReplicaSet {
+	for _, rs := range rss {
+		if replicaSetColor(rs) == color {
+			return rs
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	demov1 "k8s.io/bgd-operator/pkg/apis/demo/v1"
+)
+
+// controllerKind contains the schema.GroupVersionKind for BGDeployments
+var controllerKind = demov1.SchemeGroupVersion.WithKind("BGDeployment")
+
+// managedSelector matches every object created for any BGDeployment
+func managedSelector() labels.Selector {
+	// bgdLabel is a valid label key, NewRequirement can't fail
+	req, _ := labels.NewRequirement(bgdLabel, selection.Exists, nil)
+	return labels.NewSelector().Add(*req)
+}
+
+// bgdSelector returns the selector matching every object created for the
+// BGDeployment, regardless of its color
+func bgdSelector(bgd *demov1.BGDeployment) labels.Selector {
+	return labels.SelectorFromSet(labels.Set{bgdLabel: bgd.Name})
+}
+
+// claimReplicaSets tries to take ownership of a list of replicasets, following
+// the same rules as the ControllerRefManager of the built-in controllers: a RS
+// controlled by another owner is ignored, a RS controlled by the BGDeployment
+// is released when it no longer matches the selector, and an orphan RS
+// matching the selector is adopted.
+//
+// It returns the replicasets the BGDeployment controls after the claim. No
+// RS is adopted or released while the BGDeployment is being deleted.
+func (c *Controller) claimReplicaSets(bgd *demov1.BGDeployment, selector labels.Selector, rss []*appsv1.ReplicaSet) ([]*appsv1.ReplicaSet, error) {
+	var claimed []*appsv1.ReplicaSet
+	canAdopt := c.canAdoptFunc(bgd)
+	for _, rs := range rss {
+		controllerRef := metav1.GetControllerOf(rs)
+		if controllerRef != nil {
+			if controllerRef.UID != bgd.UID {
+				// Owned by someone else, ignore it
+				continue
+			}
+			if selector.Matches(labels.Set(rs.Labels)) {
+				claimed = append(claimed, rs)
+				continue
+			}
+			if bgd.DeletionTimestamp != nil {
+				continue
+			}
+			// Owned by us but selector doesn't match, try to release it
+			if err := c.releaseReplicaSet(bgd, rs); err != nil && !apierrors.IsNotFound(err) {
+				return nil, err
+			}
+			continue
+		}
+
+		// It's an orphan
+		if bgd.DeletionTimestamp != nil || rs.DeletionTimestamp != nil || !selector.Matches(labels.Set(rs.Labels)) {
+			continue
+		}
+		if err := canAdopt(); err != nil {
+			return nil, fmt.Errorf("can't adopt RS %v/%v (%v): %v", rs.Namespace, rs.Name, rs.UID, err)
+		}
+		adopted, err := c.adoptReplicaSet(bgd, rs)
+		if apierrors.IsNotFound(err) {
+			// The RS was deleted in the meantime, nothing to claim
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		claimed = append(claimed, adopted)
+	}
+	return claimed, nil
+}
+
+// canAdoptFunc returns a function that checks, at most once, with an uncached
+// quorum read that the BGDeployment is still the one we listed and is not
+// being deleted, before any orphan is adopted.
+func (c *Controller) canAdoptFunc(bgd *demov1.BGDeployment) func() error {
+	var checked bool
+	var result error
+	return func() error {
+		if checked {
+			return result
+		}
+		checked = true
+		fresh, err := c.bgdclientset.DemoV1().BGDeployments(bgd.Namespace).Get(bgd.Name, metav1.GetOptions{})
+		if err != nil {
+			result = err
+		} else if fresh.UID != bgd.UID {
+			result = fmt.Errorf("original BGDeployment %v/%v is gone: got uid %v, wanted %v", bgd.Namespace, bgd.Name, fresh.UID, bgd.UID)
+		} else if fresh.DeletionTimestamp != nil {
+			result = fmt.Errorf("%v/%v has just been deleted at %v", bgd.Namespace, bgd.Name, fresh.DeletionTimestamp)
+		}
+		return result
+	}
+}
+
+// adoptReplicaSet sends a patch to take control of the RS
+func (c *Controller) adoptReplicaSet(bgd *demov1.BGDeployment, rs *appsv1.ReplicaSet) (*appsv1.ReplicaSet, error) {
+	glog.V(2).Infof("adopting RS %v/%v for BGDeployment %q", rs.Namespace, rs.Name, bgd.Name)
+	// Note that ValidateOwnerReferences() will reject this patch if another
+	// OwnerReference exists with controller=true.
+	patch := fmt.Sprintf(
+		`{"metadata":{"ownerReferences":[{"apiVersion":"%s","kind":"%s","name":"%s","uid":"%s","controller":true,"blockOwnerDeletion":true}],"uid":"%s"}}`,
+		controllerKind.GroupVersion(), controllerKind.Kind, bgd.Name, bgd.UID, rs.UID)
+	return c.kubeclient.PatchReplicaSet(rs.Name, rs.Namespace, []byte(patch))
+}
+
+// releaseReplicaSet sends a patch to free the RS from the control of the
+// BGDeployment
+func (c *Controller) releaseReplicaSet(bgd *demov1.BGDeployment, rs *appsv1.ReplicaSet) error {
+	glog.V(2).Infof("releasing RS %v/%v from BGDeployment %q", rs.Namespace, rs.Name, bgd.Name)
+	patch := fmt.Sprintf(`{"metadata":{"ownerReferences":[{"$patch":"delete","uid":"%s"}],"uid":"%s"}}`, bgd.UID, rs.UID)
+	_, err := c.kubeclient.PatchReplicaSet(rs.Name, rs.Namespace, []byte(patch))
+	return err
+}
+
+// releaseService sends a patch to free the service from the control of the
+// BGDeployment
+func (c *Controller) releaseService(bgd *demov1.BGDeployment, svc *corev1.Service) error {
+	glog.V(2).Infof("releasing service %v/%v from BGDeployment %q", svc.Namespace, svc.Name, bgd.Name)
+	patch := fmt.Sprintf(`{"metadata":{"ownerReferences":[{"$patch":"delete","uid":"%s"}],"uid":"%s"}}`, bgd.UID, svc.UID)
+	_, err := c.kubeclient.PatchService(svc.Name, svc.Namespace, []byte(patch))
+	return err
+}
@@ -0,0 +1,958 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	demov1 "k8s.io/bgd-operator/pkg/apis/demo/v1"
+	"k8s.io/bgd-operator/pkg/client/clientset/versioned/fake"
+	informers "k8s.io/bgd-operator/pkg/client/informers/externalversions"
+	kubeinformers "k8s.io/client-go/informers"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+var (
+	alwaysReady        = func() bool { return true }
+	noResyncPeriodFunc = func() time.Duration { return 0 }
+)
+
+type fixture struct {
+	t *testing.T
+
+	client     *fake.Clientset
+	kubeclient *k8sfake.Clientset
+	recorder   *record.FakeRecorder
+	// Objects to put in the store.
+	bgdLister []*demov1.BGDeployment
+	rsLister  []*appsv1.ReplicaSet
+	svcLister []*corev1.Service
+	// Objects from here preloaded into NewSimpleClientset.
+	kubeobjects []runtime.Object
+	objects     []runtime.Object
+	// The managed namespaces, all of them when empty
+	namespaces []string
+}
+
+func newFixture(t *testing.T) *fixture {
+	return &fixture{t: t}
+}
+
+func (f *fixture) addBGDeployment(bgd *demov1.BGDeployment) {
+	f.bgdLister = append(f.bgdLister, bgd)
+	f.objects = append(f.objects, bgd)
+}
+
+func (f *fixture) addReplicaSet(rs *appsv1.ReplicaSet) {
+	f.rsLister = append(f.rsLister, rs)
+	f.kubeobjects = append(f.kubeobjects, rs)
+}
+
+func (f *fixture) addService(svc *corev1.Service) {
+	f.svcLister = append(f.svcLister, svc)
+	f.kubeobjects = append(f.kubeobjects, svc)
+}
+
+func (f *fixture) newController() *Controller {
+	f.client = fake.NewSimpleClientset(f.objects...)
+	f.kubeclient = k8sfake.NewSimpleClientset(f.kubeobjects...)
+	// The API server generates the names of new replicasets
+	f.kubeclient.PrependReactor("create", "replicasets", func(action core.Action) (bool, runtime.Object, error) {
+		rs := action.(core.CreateAction).GetObject().(*appsv1.ReplicaSet)
+		if rs.Name == "" {
+			rs.Name = rs.GenerateName + "new"
+		}
+		return false, nil, nil
+	})
+
+	i := informers.NewSharedInformerFactory(f.client, noResyncPeriodFunc())
+	k8sI := kubeinformers.NewSharedInformerFactory(f.kubeclient, noResyncPeriodFunc())
+
+	kubeInformers := map[string]kubeinformers.SharedInformerFactory{}
+	for _, ns := range f.namespaces {
+		kubeInformers[ns] = k8sI
+	}
+	if len(f.namespaces) == 0 {
+		kubeInformers[metav1.NamespaceAll] = k8sI
+	}
+
+	c := NewController(KubeClient(f.kubeclient), f.client,
+		i.Demo().V1().BGDeployments(), kubeInformers, nil)
+	c.cacheSynced = []cache.InformerSynced{alwaysReady}
+	f.recorder = record.NewFakeRecorder(100)
+	c.recorder = f.recorder
+
+	for _, bgd := range f.bgdLister {
+		i.Demo().V1().BGDeployments().Informer().GetIndexer().Add(bgd)
+	}
+	for _, rs := range f.rsLister {
+		k8sI.Apps().V1().ReplicaSets().Informer().GetIndexer().Add(rs)
+	}
+	for _, svc := range f.svcLister {
+		k8sI.Core().V1().Services().Informer().GetIndexer().Add(svc)
+	}
+	return c
+}
+
+// run reconciles the BGDeployment once, and returns the writes it made to
+// BGDeployments and to replicasets and services, as "verb resource" or "verb
+// resource/subresource"
+func (f *fixture) run(bgd *demov1.BGDeployment) (bgdActions, kubeActions []string) {
+	c := f.newController()
+	if err := c.Reconcile(bgdKey(bgd)); err != nil {
+		f.t.Errorf("error syncing BGDeployment: %v", err)
+	}
+	return filterInformerActions(f.client.Actions()), filterInformerActions(f.kubeclient.Actions())
+}
+
+// filterInformerActions filters list and watch actions for testing resources.
+// Since list and watch don't change resource state we can filter it to lower
+// noise level in our tests.
+func filterInformerActions(actions []core.Action) []string {
+	ret := []string{}
+	for _, action := range actions {
+		if action.GetVerb() == "list" || action.GetVerb() == "watch" || action.GetVerb() == "get" {
+			continue
+		}
+		resource := action.GetResource().Resource
+		if sub := action.GetSubresource(); sub != "" {
+			resource += "/" + sub
+		}
+		ret = append(ret, action.GetVerb()+" "+resource)
+	}
+	return ret
+}
+
+// updatedStatus returns the status written by the last sync, or nil if it did
+// not write any
+func (f *fixture) updatedStatus() *demov1.BGDeploymentStatus {
+	var status *demov1.BGDeploymentStatus
+	for _, action := range f.client.Actions() {
+		if update, ok := action.(core.UpdateAction); ok && action.GetSubresource() == "status" {
+			status = &update.GetObject().(*demov1.BGDeployment).Status
+		}
+	}
+	return status
+}
+
+// updatedSpec returns the BGDeployment written by the last sync, or nil if it
+// did not write any
+func (f *fixture) updatedSpec() *demov1.BGDeployment {
+	var bgd *demov1.BGDeployment
+	for _, action := range f.client.Actions() {
+		if update, ok := action.(core.UpdateAction); ok && action.GetSubresource() == "" {
+			bgd = update.GetObject().(*demov1.BGDeployment)
+		}
+	}
+	return bgd
+}
+
+// events returns the events recorded by the last sync
+func (f *fixture) events() []string {
+	var events []string
+	for {
+		select {
+		case event := <-f.recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+// createdReplicaSet returns the RS created by the last sync, or nil if it did
+// not create any
+func (f *fixture) createdReplicaSet() *appsv1.ReplicaSet {
+	var rs *appsv1.ReplicaSet
+	for _, action := range f.kubeclient.Actions() {
+		if create, ok := action.(core.CreateAction); ok && action.GetResource().Resource == "replicasets" {
+			rs = create.GetObject().(*appsv1.ReplicaSet)
+		}
+	}
+	return rs
+}
+
+// createdService returns the service created by the last sync, or nil if it
+// did not create any
+func (f *fixture) createdService() *corev1.Service {
+	var svc *corev1.Service
+	for _, action := range f.kubeclient.Actions() {
+		if create, ok := action.(core.CreateAction); ok && action.GetResource().Resource == "services" {
+			svc = create.GetObject().(*corev1.Service)
+		}
+	}
+	return svc
+}
+
+func int32Ptr(i int32) *int32 { return &i }
+
+func boolPtr(b bool) *bool { return &b }
+
+func newBGDeployment(name, image string) *demov1.BGDeployment {
+	return &demov1.BGDeployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: demov1.SchemeGroupVersion.String(), Kind: "BGDeployment"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       name,
+			Namespace:  metav1.NamespaceDefault,
+			UID:        types.UID(name + "-uid"),
+			Finalizers: []string{demov1.BGDeploymentFinalizer},
+		},
+		Spec: demov1.BGDeploymentSpec{
+			Replicas: int32Ptr(1),
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "nginx", Image: image}},
+				},
+			},
+		},
+	}
+}
+
+// newRS returns a RS of the given color created for bgd while it ran image,
+// with all of its pods available
+func newRS(bgd *demov1.BGDeployment, color, image string, replicas int32) *appsv1.ReplicaSet {
+	template := bgd.DeepCopy()
+	template.Spec.Template, template.Spec.Image = *podTemplate(bgd).DeepCopy(), ""
+	template.Spec.Template.Spec.Containers[0].Image = image
+	template.Spec.Replicas = &replicas
+	rs := newReplicaSet(color, template)
+	rs.Name = rs.GenerateName + image
+	rs.UID = types.UID(rs.Name + "-uid")
+	rs.Status = appsv1.ReplicaSetStatus{Replicas: replicas, ReadyReplicas: replicas, AvailableReplicas: replicas}
+	return rs
+}
+
+// newLegacyRS returns a RS of the given color created for bgd by an earlier
+// version of the operator, with all of its pods available
+func newLegacyRS(bgd *demov1.BGDeployment, color string, replicas int32) *appsv1.ReplicaSet {
+	return &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            color + "-rs",
+			Namespace:       bgd.Namespace,
+			UID:             types.UID(color + "-rs-uid"),
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(bgd, controllerKind)},
+		},
+		Spec: appsv1.ReplicaSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{colorLabel: color}},
+			Replicas: &replicas,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{colorLabel: color}},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "nginx", Image: bgd.Spec.Image}},
+				},
+			},
+		},
+		Status: appsv1.ReplicaSetStatus{Replicas: replicas, ReadyReplicas: replicas, AvailableReplicas: replicas},
+	}
+}
+
+// newLegacyService returns the service of an earlier version of the operator,
+// selecting the pods of the given color
+func newLegacyService(bgd *demov1.BGDeployment, color string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      legacyServiceName,
+			Namespace: bgd.Namespace,
+			Labels:    map[string]string{colorLabel: color},
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{colorLabel: color},
+			Type:     corev1.ServiceTypeClusterIP,
+			Ports:    []corev1.ServicePort{{Protocol: corev1.ProtocolTCP, Port: 80, TargetPort: intstr.FromInt(443)}},
+		},
+	}
+}
+
+// legacy turns bgd into a BGDeployment written for earlier versions of the
+// operator, which only has an image
+func legacy(bgd *demov1.BGDeployment) {
+	bgd.Spec.Template = corev1.PodTemplateSpec{}
+	bgd.Spec.Image = "nginx:1.7.9"
+}
+
+// markActive records that the RS served traffic
+func markActive(rs *appsv1.ReplicaSet) *appsv1.ReplicaSet {
+	rs.Annotations = map[string]string{activeSinceAnnotation: "2017-01-01T00:00:00Z"}
+	return rs
+}
+
+// unavailable drops the available pods of the RS
+func unavailable(rs *appsv1.ReplicaSet) *appsv1.ReplicaSet {
+	rs.Status.ReadyReplicas, rs.Status.AvailableReplicas = 0, 0
+	return rs
+}
+
+// rollingOut records a rollout of the RS started at the given time
+func rollingOut(bgd *demov1.BGDeployment, phase string, rs *appsv1.ReplicaSet, started time.Time) {
+	bgd.Status.Phase = phase
+	setProgressing(&bgd.Status, newRSCreatedReason, rs)
+	cond := getCondition(bgd.Status, demov1.BGDeploymentProgressing)
+	cond.LastUpdateTime = metav1.NewTime(started)
+	setCondition(&bgd.Status, *cond)
+}
+
+// awaitingPromotion records the status written by the sync that found the
+// pods of newRS available, while activeRS still serves traffic
+func awaitingPromotion(bgd *demov1.BGDeployment, activeRS, newRS *appsv1.ReplicaSet) {
+	activeColor := replicaSetColor(activeRS)
+	rollingOut(bgd, demov1.BGDeploymentAwaitingPromotion, newRS, time.Now().Add(-time.Minute))
+	bgd.Status.Replicas = activeRS.Status.Replicas
+	bgd.Status.Selector = labels.SelectorFromSet(bgdLabels(bgd, activeColor)).String()
+	bgd.Status.ActiveColor, bgd.Status.PreviewColor = activeColor, colorMap[activeColor]
+	bgd.Status.ActiveReplicaSet = replicaSetStatus(activeRS)
+	bgd.Status.PreviewReplicaSet = replicaSetStatus(newRS)
+	setAvailable(&bgd.Status, activeRS)
+	setProgressing(&bgd.Status, newRSAvailableReason, newRS)
+	setDegraded(&bgd.Status, nil)
+}
+
+func TestReconcile(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup fills in the BGDeployment and the objects of the fixture
+		setup           func(f *fixture, bgd *demov1.BGDeployment)
+		wantBGDActions  []string
+		wantKubeActions []string
+		wantPhase       string
+		// check, if set, checks the rest of the outcome
+		check func(t *testing.T, f *fixture)
+	}{
+		{
+			name:  "new BGDeployment gets a blue RS and a service",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {},
+			wantBGDActions: []string{
+				"update bgdeployments/status",
+			},
+			wantKubeActions: []string{
+				"create replicasets",
+				"create services",
+				"patch replicasets",
+			},
+			wantPhase: demov1.BGDeploymentCompleted,
+			check: func(t *testing.T, f *fixture) {
+				if color := f.updatedStatus().ActiveColor; color != "blue" {
+					t.Errorf("expected blue to be active, got %q", color)
+				}
+			},
+		},
+		{
+			name: "deprecated image runs in a single nginx container",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				legacy(bgd)
+			},
+			wantBGDActions: []string{
+				"update bgdeployments/status",
+			},
+			wantKubeActions: []string{
+				"create replicasets",
+				"create services",
+				"patch replicasets",
+			},
+			wantPhase: demov1.BGDeploymentCompleted,
+			check: func(t *testing.T, f *fixture) {
+				want := []corev1.Container{{Name: "nginx", Image: "nginx:1.7.9"}}
+				if containers := f.createdReplicaSet().Spec.Template.Spec.Containers; !reflect.DeepEqual(containers, want) {
+					t.Errorf("expected containers %+v, got %+v", want, containers)
+				}
+			},
+		},
+		{
+			name: "missing service selects the RS that served traffic last",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				bgd.Spec.Strategy.AutoPromote = boolPtr(false)
+				blue := markActive(newRS(bgd, "blue", "nginx:1.7.9", 1))
+				f.addReplicaSet(blue)
+				green := newRS(bgd, "green", "nginx:1.7.10", 1)
+				f.addReplicaSet(green)
+				awaitingPromotion(bgd, blue, green)
+			},
+			wantBGDActions: []string{},
+			wantKubeActions: []string{
+				"create services",
+			},
+			wantPhase: demov1.BGDeploymentAwaitingPromotion,
+			check: func(t *testing.T, f *fixture) {
+				if color := f.createdService().Spec.Selector[colorLabel]; color != "blue" {
+					t.Errorf("expected the service to select blue, got %q", color)
+				}
+			},
+		},
+		{
+			name: "renamed service keeps the color of the service it replaces",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				f.addReplicaSet(markActive(newRS(bgd, "blue", "nginx:1.7.9", 0)))
+				f.addReplicaSet(newRS(bgd, "green", "nginx:1.7.10", 1))
+				f.addService(newService(bgd, "green"))
+				bgd.Spec.Service.Name = "renamed"
+			},
+			wantBGDActions: []string{
+				"update bgdeployments/status",
+			},
+			wantKubeActions: []string{
+				"create services",
+				"delete services",
+				"patch replicasets",
+			},
+			wantPhase: demov1.BGDeploymentCompleted,
+			check: func(t *testing.T, f *fixture) {
+				if svc := f.createdService(); svc.Name != "renamed" || svc.Spec.Selector[colorLabel] != "green" {
+					t.Errorf("expected service \"renamed\" to select green, got %q selecting %q", svc.Name, svc.Spec.Selector[colorLabel])
+				}
+			},
+		},
+		{
+			name: "legacy replicasets get a managed RS of the color of the legacy service",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				legacy(bgd)
+				f.addReplicaSet(newLegacyRS(bgd, "blue", 0))
+				f.addReplicaSet(newLegacyRS(bgd, "green", 1))
+				f.addService(newLegacyService(bgd, "green"))
+			},
+			wantBGDActions: []string{
+				"update bgdeployments/status",
+			},
+			wantKubeActions: []string{
+				"create replicasets",
+			},
+			wantPhase: demov1.BGDeploymentWaitingForReady,
+			check: func(t *testing.T, f *fixture) {
+				rs := f.createdReplicaSet()
+				if color := replicaSetColor(rs); color != "green" {
+					t.Errorf("expected a green RS, got %q", color)
+				}
+				if rs.Spec.Template.Labels[colorLabel] != "green" || rs.Spec.Template.Labels[bgdLabel] != "test" {
+					t.Errorf("expected the pods to be labelled for the legacy service and the BGDeployment, got %v", rs.Spec.Template.Labels)
+				}
+				want := []corev1.Container{{Name: "nginx", Image: "nginx:1.7.9"}}
+				if containers := rs.Spec.Template.Spec.Containers; !reflect.DeepEqual(containers, want) {
+					t.Errorf("expected containers %+v, got %+v", want, containers)
+				}
+			},
+		},
+		{
+			name: "legacy service is replaced once the managed RS is available",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				legacy(bgd)
+				f.addReplicaSet(newLegacyRS(bgd, "blue", 1))
+				f.addReplicaSet(newLegacyRS(bgd, "green", 0))
+				f.addReplicaSet(newRS(bgd, "blue", "nginx:1.7.9", 1))
+				f.addService(newLegacyService(bgd, "blue"))
+			},
+			wantBGDActions: []string{
+				"update bgdeployments/status",
+			},
+			wantKubeActions: []string{
+				"create services",
+				"delete services",
+				"delete replicasets",
+				"delete replicasets",
+			},
+			check: func(t *testing.T, f *fixture) {
+				if svc := f.createdService(); svc.Name != "test" || svc.Spec.Selector[colorLabel] != "blue" {
+					t.Errorf("expected service \"test\" to select blue, got %q selecting %q", svc.Name, svc.Spec.Selector[colorLabel])
+				}
+			},
+		},
+		{
+			name: "legacy service is taken over when the BGDeployment uses its name",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				legacy(bgd)
+				bgd.Spec.Service.Name = legacyServiceName
+				f.addReplicaSet(newLegacyRS(bgd, "blue", 1))
+				f.addReplicaSet(newRS(bgd, "blue", "nginx:1.7.9", 1))
+				f.addService(newLegacyService(bgd, "blue"))
+			},
+			wantBGDActions: []string{
+				"update bgdeployments/status",
+			},
+			wantKubeActions: []string{
+				"patch services",
+				"delete replicasets",
+			},
+		},
+		{
+			name: "orphan RS labelled for the BGDeployment is adopted",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				blue := markActive(newRS(bgd, "blue", "nginx:1.7.10", 1))
+				blue.OwnerReferences = nil
+				f.addReplicaSet(blue)
+				f.addService(newService(bgd, "blue"))
+			},
+			wantBGDActions: []string{
+				"update bgdeployments/status",
+			},
+			wantKubeActions: []string{
+				"patch replicasets",
+			},
+			wantPhase: demov1.BGDeploymentCompleted,
+		},
+		{
+			name: "RS controlled by another object is left alone",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				f.addReplicaSet(markActive(newRS(bgd, "blue", "nginx:1.7.10", 1)))
+				green := newRS(bgd, "green", "nginx:1.7.9", 1)
+				green.OwnerReferences[0].UID = "other-uid"
+				f.addReplicaSet(green)
+				f.addService(newService(bgd, "blue"))
+			},
+			wantBGDActions: []string{
+				"update bgdeployments/status",
+			},
+			wantKubeActions: []string{},
+			wantPhase:       demov1.BGDeploymentCompleted,
+		},
+		{
+			name: "drift of the service is patched, keeping what others added",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				f.addReplicaSet(markActive(newRS(bgd, "blue", "nginx:1.7.10", 1)))
+				svc := newService(bgd, "blue")
+				svc.Labels["team"] = "a"
+				svc.Spec.Type = corev1.ServiceTypeNodePort
+				f.addService(svc)
+			},
+			wantBGDActions: []string{
+				"update bgdeployments/status",
+			},
+			wantKubeActions: []string{
+				"patch services",
+			},
+			wantPhase: demov1.BGDeploymentCompleted,
+			check: func(t *testing.T, f *fixture) {
+				svc, _ := f.kubeclient.CoreV1().Services(metav1.NamespaceDefault).Get("test", metav1.GetOptions{})
+				if svc.Spec.Type != corev1.ServiceTypeClusterIP || svc.Labels["team"] != "a" {
+					t.Errorf("expected a ClusterIP service keeping the team label, got %q with labels %v", svc.Spec.Type, svc.Labels)
+				}
+			},
+		},
+		{
+			name: "changed template deletes the stale inactive RS",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				f.addReplicaSet(markActive(newRS(bgd, "blue", "nginx:1.7.9", 1)))
+				f.addReplicaSet(newRS(bgd, "green", "nginx:1.7.8", 0))
+				f.addService(newService(bgd, "blue"))
+			},
+			wantBGDActions: []string{
+				"update bgdeployments/status",
+			},
+			wantKubeActions: []string{
+				"delete replicasets",
+			},
+			wantPhase: demov1.BGDeploymentProvisioning,
+		},
+		{
+			name: "changed template creates a new RS of the inactive color",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				f.addReplicaSet(markActive(newRS(bgd, "blue", "nginx:1.7.9", 1)))
+				f.addService(newService(bgd, "blue"))
+				bgd.Status.Phase = demov1.BGDeploymentProvisioning
+			},
+			wantBGDActions: []string{
+				"update bgdeployments/status",
+			},
+			wantKubeActions: []string{
+				"create replicasets",
+			},
+			wantPhase: demov1.BGDeploymentWaitingForReady,
+			check: func(t *testing.T, f *fixture) {
+				if cond := rolloutInProgress(*f.updatedStatus()); cond == nil || cond.Reason != newRSCreatedReason {
+					t.Errorf("expected a rollout to be in progress, got %#v", cond)
+				}
+			},
+		},
+		{
+			name: "new RS whose pods are not available is waited for",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				f.addReplicaSet(markActive(newRS(bgd, "blue", "nginx:1.7.9", 1)))
+				green := unavailable(newRS(bgd, "green", "nginx:1.7.10", 1))
+				f.addReplicaSet(green)
+				f.addService(newService(bgd, "blue"))
+				rollingOut(bgd, demov1.BGDeploymentWaitingForReady, green, time.Now())
+			},
+			wantBGDActions: []string{
+				"update bgdeployments/status",
+			},
+			wantKubeActions: []string{},
+			wantPhase:       demov1.BGDeploymentWaitingForReady,
+		},
+		{
+			name: "new RS not available within the progress deadline is scaled down",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				bgd.Spec.ProgressDeadlineSeconds = int32Ptr(60)
+				f.addReplicaSet(markActive(newRS(bgd, "blue", "nginx:1.7.9", 1)))
+				green := unavailable(newRS(bgd, "green", "nginx:1.7.10", 1))
+				f.addReplicaSet(green)
+				f.addService(newService(bgd, "blue"))
+				rollingOut(bgd, demov1.BGDeploymentWaitingForReady, green, time.Now().Add(-2*time.Minute))
+			},
+			wantBGDActions: []string{
+				"update bgdeployments/status",
+			},
+			wantKubeActions: []string{
+				"update replicasets/scale",
+			},
+			wantPhase: demov1.BGDeploymentFailed,
+			check: func(t *testing.T, f *fixture) {
+				status := f.updatedStatus()
+				if cond := getCondition(*status, demov1.BGDeploymentProgressing); cond == nil || cond.Reason != timedOutReason {
+					t.Errorf("expected the Progressing condition to have timed out, got %#v", cond)
+				}
+				if status.ActiveColor != "blue" {
+					t.Errorf("expected blue to stay active, got %q", status.ActiveColor)
+				}
+			},
+		},
+		{
+			name: "timed out RS scaled to 0 waits for the next change of the template",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				f.addReplicaSet(markActive(newRS(bgd, "blue", "nginx:1.7.9", 1)))
+				green := newRS(bgd, "green", "nginx:1.7.10", 0)
+				f.addReplicaSet(green)
+				f.addService(newService(bgd, "blue"))
+				bgd.Status.Phase = demov1.BGDeploymentFailed
+				setProgressing(&bgd.Status, timedOutReason, green)
+			},
+			wantBGDActions: []string{
+				"update bgdeployments/status",
+			},
+			wantKubeActions: []string{},
+			wantPhase:       demov1.BGDeploymentFailed,
+		},
+		{
+			name: "available new RS is promoted right away by default",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				f.addReplicaSet(markActive(newRS(bgd, "blue", "nginx:1.7.9", 1)))
+				green := newRS(bgd, "green", "nginx:1.7.10", 1)
+				f.addReplicaSet(green)
+				f.addService(newService(bgd, "blue"))
+				rollingOut(bgd, demov1.BGDeploymentWaitingForReady, green, time.Now())
+			},
+			wantBGDActions: []string{
+				"update bgdeployments/status",
+			},
+			wantKubeActions: []string{},
+			wantPhase:       demov1.BGDeploymentPromoting,
+		},
+		{
+			name: "available new RS awaits promotion without autoPromote",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				bgd.Spec.Strategy.AutoPromote = boolPtr(false)
+				f.addReplicaSet(markActive(newRS(bgd, "blue", "nginx:1.7.9", 1)))
+				green := newRS(bgd, "green", "nginx:1.7.10", 1)
+				f.addReplicaSet(green)
+				f.addService(newService(bgd, "blue"))
+				rollingOut(bgd, demov1.BGDeploymentWaitingForReady, green, time.Now())
+			},
+			wantBGDActions: []string{
+				"update bgdeployments/status",
+			},
+			wantKubeActions: []string{},
+			wantPhase:       demov1.BGDeploymentAwaitingPromotion,
+		},
+		{
+			name: "promote annotation naming another RS promotes nothing",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				bgd.Spec.Strategy.AutoPromote = boolPtr(false)
+				bgd.Annotations = map[string]string{demov1.PromoteAnnotation: "test-green-nginx:1.7.8"}
+				blue := markActive(newRS(bgd, "blue", "nginx:1.7.9", 1))
+				f.addReplicaSet(blue)
+				green := newRS(bgd, "green", "nginx:1.7.10", 1)
+				f.addReplicaSet(green)
+				f.addService(newService(bgd, "blue"))
+				awaitingPromotion(bgd, blue, green)
+			},
+			wantBGDActions:  []string{},
+			wantKubeActions: []string{},
+			wantPhase:       demov1.BGDeploymentAwaitingPromotion,
+			check: func(t *testing.T, f *fixture) {
+				if events := f.events(); len(events) != 0 {
+					t.Errorf("expected no event, got %v", events)
+				}
+			},
+		},
+		{
+			name: "promote annotation naming the new RS promotes it",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				bgd.Spec.Strategy.AutoPromote = boolPtr(false)
+				f.addReplicaSet(markActive(newRS(bgd, "blue", "nginx:1.7.9", 1)))
+				green := newRS(bgd, "green", "nginx:1.7.10", 1)
+				f.addReplicaSet(green)
+				f.addService(newService(bgd, "blue"))
+				bgd.Annotations = map[string]string{demov1.PromoteAnnotation: green.Name}
+				rollingOut(bgd, demov1.BGDeploymentAwaitingPromotion, green, time.Now())
+			},
+			wantBGDActions: []string{
+				"update bgdeployments/status",
+			},
+			wantKubeActions: []string{},
+			wantPhase:       demov1.BGDeploymentPromoting,
+		},
+		{
+			name: "promoting switches the service over to the new RS",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				f.addReplicaSet(markActive(newRS(bgd, "blue", "nginx:1.7.9", 1)))
+				green := newRS(bgd, "green", "nginx:1.7.10", 1)
+				f.addReplicaSet(green)
+				f.addService(newService(bgd, "blue"))
+				bgd.Status.Phase = demov1.BGDeploymentPromoting
+				setProgressing(&bgd.Status, newRSAvailableReason, green)
+			},
+			wantBGDActions: []string{
+				"update bgdeployments/status",
+			},
+			wantKubeActions: []string{
+				"patch services",
+				"patch replicasets",
+			},
+			wantPhase: demov1.BGDeploymentScalingDownOld,
+			check: func(t *testing.T, f *fixture) {
+				if f.updatedStatus().LastPromotionTime == nil {
+					t.Errorf("expected the promotion to be recorded")
+				}
+			},
+		},
+		{
+			name: "old RS is scaled down once the service is switched over",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				f.addReplicaSet(newRS(bgd, "blue", "nginx:1.7.9", 1))
+				green := markActive(newRS(bgd, "green", "nginx:1.7.10", 1))
+				f.addReplicaSet(green)
+				f.addService(newService(bgd, "green"))
+				bgd.Annotations = map[string]string{demov1.PromoteAnnotation: green.Name}
+				bgd.Status.Phase = demov1.BGDeploymentScalingDownOld
+				setProgressing(&bgd.Status, newRSAvailableReason, green)
+			},
+			wantBGDActions: []string{
+				"patch bgdeployments",
+				"update bgdeployments/status",
+			},
+			wantKubeActions: []string{
+				"update replicasets/scale",
+			},
+			wantPhase: demov1.BGDeploymentCompleted,
+		},
+		{
+			name: "rollback after a promotion scales the previous RS back up",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				f.addReplicaSet(markActive(newRS(bgd, "blue", "nginx:1.7.9", 0)))
+				green := markActive(newRS(bgd, "green", "nginx:1.7.10", 1))
+				f.addReplicaSet(green)
+				f.addService(newService(bgd, "green"))
+				bgd.Spec.RollbackTo = &demov1.BGDeploymentRollback{}
+				bgd.Status.Phase = demov1.BGDeploymentCompleted
+				setProgressing(&bgd.Status, newRSAvailableReason, green)
+			},
+			wantBGDActions: []string{
+				"update bgdeployments",
+				"update bgdeployments/status",
+			},
+			wantKubeActions: []string{
+				"patch replicasets",
+				"update replicasets/scale",
+			},
+			wantPhase: demov1.BGDeploymentWaitingForReady,
+			check: func(t *testing.T, f *fixture) {
+				bgd := f.updatedSpec()
+				if image := bgd.Spec.Template.Spec.Containers[0].Image; image != "nginx:1.7.9" {
+					t.Errorf("expected the template to be rolled back to nginx:1.7.9, got %q", image)
+				}
+				if bgd.Spec.RollbackTo != nil {
+					t.Errorf("expected rollbackTo to be cleared")
+				}
+				want := &demov1.BGDeploymentRollbackStatus{FromReplicaSet: "test-green-nginx:1.7.10", ToReplicaSet: "test-blue-nginx:1.7.9"}
+				if got := f.updatedStatus().LastRollback; got == nil || got.FromReplicaSet != want.FromReplicaSet || got.ToReplicaSet != want.ToReplicaSet {
+					t.Errorf("expected the rollback to be recorded as %+v, got %+v", want, got)
+				}
+			},
+		},
+		{
+			name: "rollback during an unpromoted rollout aborts it",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				bgd.Spec.Strategy.AutoPromote = boolPtr(false)
+				blue := markActive(newRS(bgd, "blue", "nginx:1.7.9", 1))
+				f.addReplicaSet(blue)
+				green := newRS(bgd, "green", "nginx:1.7.10", 1)
+				f.addReplicaSet(green)
+				f.addService(newService(bgd, "blue"))
+				bgd.Spec.RollbackTo = &demov1.BGDeploymentRollback{}
+				rollingOut(bgd, demov1.BGDeploymentAwaitingPromotion, green, time.Now())
+			},
+			wantBGDActions: []string{
+				"update bgdeployments",
+				"update bgdeployments/status",
+			},
+			wantKubeActions: []string{
+				"update replicasets/scale",
+			},
+			wantPhase: demov1.BGDeploymentCompleted,
+			check: func(t *testing.T, f *fixture) {
+				bgd := f.updatedSpec()
+				if image := bgd.Spec.Template.Spec.Containers[0].Image; image != "nginx:1.7.9" {
+					t.Errorf("expected the template of the active RS to be written back, got %q", image)
+				}
+				status := f.updatedStatus()
+				if status.ActiveColor != "blue" {
+					t.Errorf("expected blue to stay active, got %q", status.ActiveColor)
+				}
+				if rolloutInProgress(*status) != nil {
+					t.Errorf("expected no rollout in progress")
+				}
+				scale := f.kubeclient.Actions()[len(f.kubeclient.Actions())-1].(core.UpdateAction).GetObject()
+				if name := scale.(metav1.Object).GetName(); name != "test-green-nginx:1.7.10" {
+					t.Errorf("expected the new RS to be scaled down, got %q", name)
+				}
+			},
+		},
+		{
+			name: "rollback to a RS that never served traffic is refused",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				f.addReplicaSet(newRS(bgd, "blue", "nginx:1.7.9", 0))
+				green := markActive(newRS(bgd, "green", "nginx:1.7.10", 1))
+				f.addReplicaSet(green)
+				f.addService(newService(bgd, "green"))
+				bgd.Spec.RollbackTo = &demov1.BGDeploymentRollback{}
+				bgd.Status.Phase = demov1.BGDeploymentCompleted
+			},
+			wantBGDActions: []string{
+				"update bgdeployments",
+				"update bgdeployments/status",
+			},
+			wantKubeActions: []string{},
+			wantPhase:       demov1.BGDeploymentFailed,
+			check: func(t *testing.T, f *fixture) {
+				if image := f.updatedSpec().Spec.Template.Spec.Containers[0].Image; image != "nginx:1.7.10" {
+					t.Errorf("expected the template to be left alone, got %q", image)
+				}
+			},
+		},
+		{
+			name: "deleted BGDeployment deletes its services, then its replicasets",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				f.addReplicaSet(markActive(newRS(bgd, "blue", "nginx:1.7.9", 0)))
+				f.addReplicaSet(markActive(newRS(bgd, "green", "nginx:1.7.10", 1)))
+				f.addService(newService(bgd, "green"))
+				// Released by an earlier BGDeployment of the same name
+				orphaned := newService(bgd, "blue")
+				orphaned.Name, orphaned.OwnerReferences = "orphaned", nil
+				f.addService(orphaned)
+				bgd.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+			},
+			wantBGDActions: []string{
+				"update bgdeployments",
+			},
+			wantKubeActions: []string{
+				"delete services",
+				"delete replicasets",
+				"delete replicasets",
+			},
+			check: func(t *testing.T, f *fixture) {
+				for _, action := range f.kubeclient.Actions() {
+					if action, ok := action.(core.DeleteAction); ok && action.GetName() == "orphaned" {
+						t.Errorf("expected the service the BGDeployment does not control to be left alone")
+					}
+				}
+				if finalizers := f.updatedSpec().Finalizers; len(finalizers) != 0 {
+					t.Errorf("expected the finalizer to be removed, got %v", finalizers)
+				}
+			},
+		},
+		{
+			name: "BGDeployment of a namespace that is not managed is left alone",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				f.namespaces = []string{"team-a"}
+			},
+			wantBGDActions:  []string{},
+			wantKubeActions: []string{},
+		},
+		{
+			name: "deleted BGDeployment of a namespace that is no longer managed is finalized",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				f.namespaces = []string{"team-a"}
+				f.addReplicaSet(markActive(newRS(bgd, "blue", "nginx:1.7.10", 1)))
+				f.addService(newService(bgd, "blue"))
+				bgd.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+			},
+			wantBGDActions: []string{
+				"update bgdeployments",
+			},
+			wantKubeActions: []string{
+				"delete services",
+				"delete replicasets",
+			},
+		},
+		{
+			name: "deleted BGDeployment with the Orphan policy releases its services and replicasets",
+			setup: func(f *fixture, bgd *demov1.BGDeployment) {
+				bgd.Spec.RetentionPolicy = demov1.BGDeploymentRetentionOrphan
+				f.addReplicaSet(markActive(newRS(bgd, "blue", "nginx:1.7.10", 1)))
+				f.addService(newService(bgd, "blue"))
+				bgd.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+			},
+			wantBGDActions: []string{
+				"update bgdeployments",
+			},
+			wantKubeActions: []string{
+				"patch services",
+				"patch replicasets",
+			},
+			check: func(t *testing.T, f *fixture) {
+				svc, _ := f.kubeclient.CoreV1().Services(metav1.NamespaceDefault).Get("test", metav1.GetOptions{})
+				rs, _ := f.kubeclient.AppsV1().ReplicaSets(metav1.NamespaceDefault).Get("test-blue-nginx:1.7.10", metav1.GetOptions{})
+				if metav1.GetControllerOf(svc) != nil || metav1.GetControllerOf(rs) != nil {
+					t.Errorf("expected the service and the RS to be released")
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			bgd := newBGDeployment("test", "nginx:1.7.10")
+			test.setup(f, bgd)
+			f.addBGDeployment(bgd)
+
+			bgdActions, kubeActions := f.run(bgd)
+			if !reflect.DeepEqual(bgdActions, test.wantBGDActions) {
+				t.Errorf("expected BGDeployment actions %v, got %v", test.wantBGDActions, bgdActions)
+			}
+			if !reflect.DeepEqual(kubeActions, test.wantKubeActions) {
+				t.Errorf("expected replicaset and service actions %v, got %v", test.wantKubeActions, kubeActions)
+			}
+			phase := bgd.Status.Phase
+			if status := f.updatedStatus(); status != nil {
+				phase = status.Phase
+			}
+			if phase != test.wantPhase {
+				t.Errorf("expected phase %q, got %q", test.wantPhase, phase)
+			}
+			if test.check != nil {
+				test.check(t, f)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// expectationsTimeout is how long a write is waited for to show up in the
+// informers, before the BGDeployment is synced regardless. The informers are a
+// lot faster than that, unless a watch event was missed.
+const expectationsTimeout = 5 * time.Minute
+
+// expectations tracks, for each BGDeployment, the writes of its replicasets
+// and services that the informers have not observed yet. A BGDeployment is not
+// synced until they are, as syncing it from stale listers could e.g. create a
+// second RS for the same rollout.
+type expectations struct {
+	mu sync.Mutex
+	// pending maps the key of a BGDeployment to its writes
+	pending map[string][]expectation
+}
+
+// expectation is a write waiting to be observed
+type expectation struct {
+	// observed returns whether the listers reflect the write
+	observed  func() bool
+	timestamp time.Time
+}
+
+// newExpectations returns an empty set of expectations
+func newExpectations() *expectations {
+	return &expectations{pending: map[string][]expectation{}}
+}
+
+// expect records a write for the BGDeployment with the given key, which is
+// observed once observed returns true
+func (e *expectations) expect(key string, observed func() bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.pending[key] = append(e.pending[key], expectation{observed: observed, timestamp: time.Now()})
+}
+
+// satisfied returns whether all the writes for the BGDeployment with the given
+// key have been observed. Observed and expired writes are forgotten.
+func (e *expectations) satisfied(key string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var pending []expectation
+	for _, exp := range e.pending[key] {
+		if exp.observed() {
+			continue
+		}
+		if time.Since(exp.timestamp) > expectationsTimeout {
+			glog.V(2).Infof("a write for BGDeployment %q was not observed within %v, syncing it regardless", key, expectationsTimeout)
+			continue
+		}
+		pending = append(pending, exp)
+	}
+	if len(pending) == 0 {
+		delete(e.pending, key)
+		return true
+	}
+	e.pending[key] = pending
+	return false
+}
+
+// forget drops the writes for the BGDeployment with the given key
+func (e *expectations) forget(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.pending, key)
+}
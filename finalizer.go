@@ -0,0 +1,138 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	demov1 "k8s.io/bgd-operator/pkg/apis/demo/v1"
+)
+
+// hasFinalizer returns whether the BGDeployment carries the finalizer of the
+// operator
+func hasFinalizer(bgd *demov1.BGDeployment) bool {
+	for _, f := range bgd.Finalizers {
+		if f == demov1.BGDeploymentFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// addFinalizer adds the finalizer of the operator to the BGDeployment, so that
+// its deletion waits for finalize
+func (c *Controller) addFinalizer(bgd *demov1.BGDeployment) error {
+	bgdCopy := bgd.DeepCopy()
+	bgdCopy.Finalizers = append(bgdCopy.Finalizers, demov1.BGDeploymentFinalizer)
+	if _, err := c.bgdclientset.DemoV1().BGDeployments(bgd.Namespace).Update(bgdCopy); err != nil {
+		return fmt.Errorf("failed to add finalizer to BGDeployment %q: %v", bgd.Name, err)
+	}
+	return nil
+}
+
+// removeFinalizer removes the finalizer of the operator from the BGDeployment,
+// which lets its deletion complete
+func (c *Controller) removeFinalizer(bgd *demov1.BGDeployment) error {
+	bgdCopy := bgd.DeepCopy()
+	bgdCopy.Finalizers = nil
+	for _, f := range bgd.Finalizers {
+		if f != demov1.BGDeploymentFinalizer {
+			bgdCopy.Finalizers = append(bgdCopy.Finalizers, f)
+		}
+	}
+	_, err := c.bgdclientset.DemoV1().BGDeployments(bgd.Namespace).Update(bgdCopy)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to remove finalizer from BGDeployment %q: %v", bgd.Name, err)
+	}
+	return nil
+}
+
+// finalize tears down the services and replicasets of a BGDeployment that is
+// being deleted, then removes its finalizer. The services go first, so that
+// traffic is drained from the pods before they are deleted. With the Orphan
+// retention policy, they are all released instead, and keep running.
+func (c *Controller) finalize(bgd *demov1.BGDeployment) error {
+	if !hasFinalizer(bgd) {
+		return nil
+	}
+
+	svcs, err := c.ownedServices(bgd)
+	if err != nil {
+		return err
+	}
+	rss, err := c.kubeclient.ListReplicaSet(bgd.Namespace, bgdSelector(bgd))
+	if err != nil {
+		return fmt.Errorf("failed to list RSs: %v", err)
+	}
+
+	orphan := bgd.Spec.RetentionPolicy == demov1.BGDeploymentRetentionOrphan
+	for _, svc := range svcs {
+		if orphan {
+			err = c.releaseService(bgd, svc)
+		} else {
+			glog.V(2).Infof("deleting service %v/%v of BGDeployment %q", svc.Namespace, svc.Name, bgd.Name)
+			err = c.kubeclient.DeleteService(svc.Name, svc.Namespace)
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to tear down service %q: %v", svc.Name, err)
+		}
+	}
+	for i := range rss.Items {
+		rs := &rss.Items[i]
+		if !metav1.IsControlledBy(rs, bgd) {
+			continue
+		}
+		if orphan {
+			err = c.releaseReplicaSet(bgd, rs)
+		} else {
+			glog.V(2).Infof("deleting RS %v/%v of BGDeployment %q", rs.Namespace, rs.Name, bgd.Name)
+			err = c.kubeclient.DeleteReplicaSet(rs)
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to tear down RS %q: %v", rs.Name, err)
+		}
+	}
+
+	if err := c.removeFinalizer(bgd); err != nil {
+		return err
+	}
+	forgetBGDeploymentMetrics(bgd)
+	return nil
+}
+
+// ownedServices returns the services the BGDeployment controls. Services
+// labelled with its name but controlled by nothing, e.g. the ones released by
+// an earlier BGDeployment of the same name with the Orphan retention policy,
+// are left alone.
+func (c *Controller) ownedServices(bgd *demov1.BGDeployment) ([]*corev1.Service, error) {
+	svcList, err := c.kubeclient.ListService(bgd.Namespace, bgdSelector(bgd))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services: %v", err)
+	}
+	var svcs []*corev1.Service
+	for i := range svcList.Items {
+		svc := &svcList.Items[i]
+		if !metav1.IsControlledBy(svc, bgd) {
+			continue
+		}
+		svcs = append(svcs, svc)
+	}
+	return svcs, nil
+}
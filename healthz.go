@@ -0,0 +1,85 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// workerStallTimeout is how long the workers may go without finishing the sync
+// of a BGDeployment while some are waiting in the workqueue, before the
+// operator is reported as unhealthy
+const workerStallTimeout = 5 * time.Minute
+
+// healthCheck is a named check of the health of the operator
+type healthCheck struct {
+	name  string
+	check func(r *http.Request) error
+}
+
+// healthHandler serves the outcome of the checks, and fails unless they all
+// pass. The outcome of every check is listed in the body.
+func healthHandler(checks ...healthCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body bytes.Buffer
+		failed := false
+		for _, c := range checks {
+			if err := c.check(r); err != nil {
+				fmt.Fprintf(&body, "[-]%s failed: %v\n", c.name, err)
+				failed = true
+				continue
+			}
+			fmt.Fprintf(&body, "[+]%s ok\n", c.name)
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if failed {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(&body, "%s check failed\n", r.URL.Path)
+		} else {
+			fmt.Fprintf(&body, "%s check passed\n", r.URL.Path)
+		}
+		w.Write(body.Bytes())
+	}
+}
+
+// checkCacheSync fails until the informers of the controller have synced
+func (c *Controller) checkCacheSync(r *http.Request) error {
+	for _, synced := range c.cacheSynced {
+		if !synced() {
+			return fmt.Errorf("informer caches are not synced")
+		}
+	}
+	return nil
+}
+
+// checkWorkers fails when BGDeployments are waiting in the workqueue, but the
+// workers have not finished syncing any for workerStallTimeout. It passes
+// before the workers are started.
+func (c *Controller) checkWorkers(r *http.Request) error {
+	lastProgress := atomic.LoadInt64(&c.lastProgress)
+	if lastProgress == 0 || c.workqueue.Len() == 0 {
+		return nil
+	}
+	if since := time.Since(time.Unix(0, lastProgress)); since > workerStallTimeout {
+		return fmt.Errorf("%d BGDeployments are waiting, but no worker finished a sync for %v", c.workqueue.Len(), since.Round(time.Second))
+	}
+	return nil
+}
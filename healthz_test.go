@@ -0,0 +1,124 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestHealthHandler(t *testing.T) {
+	pass := healthCheck{name: "pass", check: func(r *http.Request) error { return nil }}
+	fail := healthCheck{name: "fail", check: func(r *http.Request) error { return fmt.Errorf("broken") }}
+
+	tests := []struct {
+		name     string
+		checks   []healthCheck
+		wantCode int
+		wantBody []string
+	}{
+		{
+			name:     "all checks pass",
+			checks:   []healthCheck{pass},
+			wantCode: http.StatusOK,
+			wantBody: []string{"[+]pass ok", "/healthz check passed"},
+		},
+		{
+			name:     "a failing check fails the probe",
+			checks:   []healthCheck{pass, fail},
+			wantCode: http.StatusInternalServerError,
+			wantBody: []string{"[+]pass ok", "[-]fail failed: broken", "/healthz check failed"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			healthHandler(test.checks...)(w, httptest.NewRequest("GET", "/healthz", nil))
+			if w.Code != test.wantCode {
+				t.Errorf("expected status %d, got %d", test.wantCode, w.Code)
+			}
+			for _, line := range test.wantBody {
+				if !strings.Contains(w.Body.String(), line) {
+					t.Errorf("expected %q in the body, got %q", line, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestCheckCacheSync(t *testing.T) {
+	c := &Controller{cacheSynced: []cache.InformerSynced{alwaysReady}}
+	if err := c.checkCacheSync(nil); err != nil {
+		t.Errorf("expected synced caches to pass, got %v", err)
+	}
+	c.cacheSynced = append(c.cacheSynced, func() bool { return false })
+	if err := c.checkCacheSync(nil); err == nil {
+		t.Errorf("expected a cache that is not synced to fail")
+	}
+}
+
+func TestCheckWorkers(t *testing.T) {
+	tests := []struct {
+		name         string
+		lastProgress time.Time
+		queued       bool
+		wantErr      bool
+	}{
+		{
+			name:   "workers not started",
+			queued: true,
+		},
+		{
+			name:         "empty workqueue",
+			lastProgress: time.Now().Add(-time.Hour),
+		},
+		{
+			name:         "recent progress",
+			lastProgress: time.Now(),
+			queued:       true,
+		},
+		{
+			name:         "stalled workers",
+			lastProgress: time.Now().Add(-2 * workerStallTimeout),
+			queued:       true,
+			wantErr:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &Controller{workqueue: workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())}
+			defer c.workqueue.ShutDown()
+			if !test.lastProgress.IsZero() {
+				c.lastProgress = test.lastProgress.UnixNano()
+			}
+			if test.queued {
+				c.workqueue.Add("default/test")
+			}
+			if err := c.checkWorkers(nil); (err != nil) != test.wantErr {
+				t.Errorf("expected error %v, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
@@ -16,21 +16,35 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"flag"
-	corev1 "k8s.io/api/core/v1"
-	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	demov1 "k8s.io/bgd-operator/pkg/apis/demo/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	clientset "k8s.io/bgd-operator/pkg/client/clientset/versioned"
+	informers "k8s.io/bgd-operator/pkg/client/informers/externalversions"
+	"k8s.io/bgd-operator/pkg/signals"
+	kubeinformers "k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 )
 
+// leaseName is the name of the lease the replicas of the operator elect their
+// leader with
+const leaseName = "bgd-operator"
+
 // GetClientConfig returns rest config, if path not specified assume in cluster config
 func GetClientConfig(kubeconfig string) (*rest.Config, error) {
 	if kubeconfig != "" {
@@ -41,150 +55,202 @@ func GetClientConfig(kubeconfig string) (*rest.Config, error) {
 
 func main() {
 	kubeconf := flag.String("kubeconf", "admin.conf", "Path to a kube config. Only required if out-of-cluster.")
+	workers := flag.Int("workers", 2, "Number of BGDeployments that are reconciled concurrently.")
+	namespaces := flag.String("namespaces", "", "Comma-separated list of namespaces whose BGDeployments are managed. All namespaces are managed when empty.")
+	namespaceSelector := flag.String("namespace-selector", "", "Label selector of the namespaces whose BGDeployments are managed, instead of a list of namespaces.")
+	metricsAddr := flag.String("metrics-addr", ":8080", "Address the /metrics endpoint is served on. Metrics are not served when empty.")
+	healthAddr := flag.String("health-addr", ":8081", "Address the /healthz and /readyz endpoints are served on. They are not served when empty.")
+	leaderElect := flag.Bool("leader-elect", true, "Elect a leader among the replicas of the operator through a lease, so that only the leader manages BGDeployments.")
+	leaseDuration := flag.Duration("leader-elect-lease-duration", 15*time.Second, "Duration non-leader replicas wait after the last renewal of the lease before taking it over.")
+	renewDeadline := flag.Duration("leader-elect-renew-deadline", 10*time.Second, "Duration the leader retries renewing the lease for before giving up leadership. Must be less than the lease duration.")
+	retryPeriod := flag.Duration("leader-elect-retry-period", 2*time.Second, "Duration replicas wait between attempts to acquire or renew the lease.")
+	leaseNamespace := flag.String("leader-elect-namespace", "default", "Namespace of the lease used for leader election.")
 	flag.Parse()
 
+	if *namespaces != "" && *namespaceSelector != "" {
+		panic("-namespaces and -namespace-selector are mutually exclusive")
+	}
+	if _, err := labels.Parse(*namespaceSelector); err != nil {
+		panic(fmt.Errorf("Error parsing -namespace-selector: %s", err.Error()))
+	}
+
 	config, err := GetClientConfig(*kubeconf)
 	if err != nil {
 		panic(err.Error())
 	}
 
-	// Create a new clientset which includes the CRD schema
-	crdcs, scheme, err := NewClient(config)
+	// Create a clientset for replicasets and services
+	kubeClient, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		panic(err)
+		panic(fmt.Errorf("Error building kubernetes clientset: %s", err.Error()))
 	}
 
-	// Create a CRD client interface
-	kubeClient, err := kubernetes.NewForConfig(config)
+	// Create a clientset for BGDeployments, generated from their API types
+	bgdClient, err := clientset.NewForConfig(config)
 	if err != nil {
-		panic(fmt.Errorf("Error building kubernetes clientset: %s", err.Error()))
+		panic(fmt.Errorf("Error building BGDeployment clientset: %s", err.Error()))
 	}
 
-	crdclient := CrdClient(kubeClient, crdcs, scheme, "default")
-	image := "nginx:1.7.9"
-	colorMap := map[string]string{"blue": "green", "green": "blue"}
-	var rs *extensionsv1beta1.ReplicaSet
-	var svc *corev1.Service
-
-	// Create an informer that watches changes in BGDeployment custom resource
-	_, controller := cache.NewInformer(
-		crdclient.NewListWatch(),
-		&demov1.BGDeployment{},
-		1*time.Minute,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				fmt.Printf("Add: %+v\n", obj)
-				bgd := obj.(*demov1.BGDeployment)
-
-				// Create a blue RS along with CRD creation
-				rs, err = crdclient.CreateReplicaSet("blue-rs", "blue", bgd)
-				if err == nil {
-					fmt.Printf("created replicaset %q\n", rs.Name)
-				} else if apierrors.IsAlreadyExists(err) {
-					fmt.Printf("replicaset already exists")
-				} else {
-					panic(err)
-				}
+	// Create an informer that watches changes in BGDeployment custom resources
+	// of all namespaces, so that BGDeployments that are deleted are finalized
+	// even outside of the managed namespaces
+	var namespaceList []string
+	for _, ns := range strings.Split(*namespaces, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			namespaceList = append(namespaceList, ns)
+		}
+	}
+	bgdInformerFactory := informers.NewSharedInformerFactory(bgdClient, 1*time.Minute)
+	// The replicasets and services are only watched in the namespaces that
+	// are listed, if any, and otherwise in all namespaces
+	kubeInformerFactories := map[string]kubeinformers.SharedInformerFactory{}
+	for _, ns := range namespaceList {
+		kubeInformerFactories[ns] = kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, 1*time.Minute, kubeinformers.WithNamespace(ns))
+	}
+	if len(namespaceList) == 0 {
+		kubeInformerFactories[metav1.NamespaceAll] = kubeinformers.NewSharedInformerFactory(kubeClient, 1*time.Minute)
+	}
+
+	// Create an informer that only lists the namespaces matching the
+	// namespace selector, if any
+	var nsInformerFactory kubeinformers.SharedInformerFactory
+	var nsInformer coreinformers.NamespaceInformer
+	if *namespaceSelector != "" {
+		nsInformerFactory = kubeinformers.NewFilteredSharedInformerFactory(kubeClient, 1*time.Minute, metav1.NamespaceAll, func(options *metav1.ListOptions) {
+			options.LabelSelector = *namespaceSelector
+		})
+		nsInformer = nsInformerFactory.Core().V1().Namespaces()
+	}
+
+	controller := NewController(KubeClient(kubeClient), bgdClient,
+		bgdInformerFactory.Demo().V1().BGDeployments(),
+		kubeInformerFactories, nsInformer)
+
+	// Serve the Prometheus metrics of the operator
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		go func() {
+			glog.Fatal(http.ListenAndServe(*metricsAddr, mux))
+		}()
+	}
 
-				// Create a service along with CRD creation
-				svc, err = crdclient.CreateService(bgd.Namespace)
-				if err != nil {
-					panic(fmt.Sprintf("failed to create service: %v", err))
+	// leading is closed once this replica leads, i.e. right away without
+	// leader election
+	leading := make(chan struct{})
+	if !*leaderElect {
+		close(leading)
+	}
+
+	// Serve the liveness and readiness probes of the operator. The leader
+	// election check fails when the leader could not renew the lease for a
+	// while. Replicas that do not lead run no informers, and are ready.
+	leaderHealth := leaderelection.NewLeaderHealthzAdaptor(20 * time.Second)
+	if *healthAddr != "" {
+		leaderCheck := healthCheck{name: "leader-election", check: leaderHealth.Check}
+		mux := http.NewServeMux()
+		mux.Handle("/healthz", healthHandler(
+			leaderCheck,
+			healthCheck{name: "workers", check: controller.checkWorkers},
+		))
+		mux.Handle("/readyz", healthHandler(
+			leaderCheck,
+			healthCheck{name: "informer-sync", check: func(r *http.Request) error {
+				select {
+				case <-leading:
+					return controller.checkCacheSync(r)
+				default:
+					return nil
 				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				fmt.Printf("Delete: %+v\n", obj)
+			}},
+		))
+		go func() {
+			glog.Fatal(http.ListenAndServe(*healthAddr, mux))
+		}()
+	}
+
+	// The stop channel is closed on SIGTERM or SIGINT. The workers then finish
+	// the step of the rollout they are at and persist it in the status of the
+	// BGDeployment before the operator exits.
+	stop := signals.SetupSignalHandler()
+	run := func() {
+		go bgdInformerFactory.Start(stop)
+		for _, factory := range kubeInformerFactories {
+			go factory.Start(stop)
+		}
+		if nsInformerFactory != nil {
+			go nsInformerFactory.Start(stop)
+		}
+
+		if err := controller.Run(*workers, stop); err != nil {
+			panic(fmt.Errorf("Error running BGDeployment controller: %s", err.Error()))
+		}
+	}
+	defer glog.Flush()
+
+	if !*leaderElect {
+		run()
+		return
+	}
 
-				// Delete service when the BGDeployment custom resource is deleted
-				err = crdclient.DeleteService(svc)
-				if err != nil {
-					panic(fmt.Sprintf("failed to delete service when the BGDeployment custom resource is deleted: %v", err))
+	// Only the leader starts the informers and the workers. The lease is
+	// released once the workers are done, so that another replica takes over
+	// right away rather than after the lease duration, and never while this
+	// one still changes BGDeployments.
+	hostname, err := os.Hostname()
+	if err != nil {
+		panic(fmt.Errorf("Error getting hostname: %s", err.Error()))
+	}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Namespace: *leaseNamespace,
+			Name:      leaseName,
+		},
+		Client: kubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity:      hostname + "_" + string(uuid.NewUUID()),
+			EventRecorder: controller.recorder,
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			// Stop waiting for the lease
+			cancel()
+		case <-leading:
+		}
+	}()
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   *leaseDuration,
+		RenewDeadline:   *renewDeadline,
+		RetryPeriod:     *retryPeriod,
+		ReleaseOnCancel: true,
+		WatchDog:        leaderHealth,
+		Name:            leaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				close(leading)
+				run()
+				cancel()
+			},
+			OnStoppedLeading: func() {
+				select {
+				case <-stop:
+					glog.Info("Released leadership")
+				default:
+					// Another replica may already be managing the
+					// BGDeployments, stop before getting in its way
+					glog.Fatalf("Lost leadership of lease %s/%s", *leaseNamespace, leaseName)
 				}
 			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				fmt.Printf("Update Old: %+v\n\nNew: %+v\n", oldObj, newObj)
-				bgd := newObj.(*demov1.BGDeployment)
-				newImage := bgd.Spec.Image
-
-				// Only create the new RS when the image is changed
-				if newImage != image {
-					image = newImage
-					var newColor string
-
-					// Before creating another RS, look for RS with zero replica
-					// If the RS with zero replica exists, update new color with its color and delete it
-					// Else, update the new color with another color
-					rss, err := crdclient.ListReplicaSet(bgd.Namespace)
-					if err != nil {
-						panic(fmt.Sprintf("failed to list RSs: %v", err))
-					}
-					for _, curRS := range rss.Items {
-						selectorMap, err := metav1.LabelSelectorAsMap(curRS.Spec.Selector)
-						if err != nil {
-							panic(fmt.Sprintf("failed to convert label selector of RS %q to a map: %v", curRS.Name, err))
-						}
-						curColor := selectorMap["color"]
-
-						if curRS.Status.AvailableReplicas == 0 {
-							// Update the new color with color of the RS with zero replica
-							newColor = curColor
-
-							// Delete the RS with zero replica
-							err = crdclient.DeleteReplicaSet(&curRS)
-							if err != nil {
-								panic(fmt.Sprintf("failed to delete RS %q with zero replica before creating a new RS with newest image name: %v", curRS.Name, err))
-							}
-
-							// Immediate break out for the loop to prevent the new color from being updated again
-							break
-						} else {
-							// Update the new color with another color
-							newColor = colorMap[curColor]
-						}
-					}
-
-					// Create a new RS with the new color
-					newRS, err := crdclient.CreateReplicaSet(fmt.Sprintf("%s-rs", newColor), newColor, bgd)
-					if err != nil {
-						panic(fmt.Sprintf("failed to create new RS when image is changed: %v", err))
-					}
-
-					// Determine whether all pods of the new RS are available (i.e., ready)
-					allNewPodsAvailable := crdclient.WaitAllPodsAvailable(newRS, 100*time.Millisecond, 5*time.Second)
-					if allNewPodsAvailable {
-						// Update service to point to the new RS
-						svc, err = crdclient.UpdateService(svc.Name, bgd.Namespace, func(service *corev1.Service) {
-							updatedLabels := map[string]string{"color": newColor}
-							service.Labels = updatedLabels
-							service.Spec.Selector = updatedLabels
-						})
-						if err != nil {
-							panic(fmt.Sprintf("failed to update service to point to new RS, %q: %v", newRS.Name, err))
-						}
-
-						// Scale down the old RS to zero replica
-						err = crdclient.ScaleReplicaSet(rs, 0)
-						if err != nil {
-							panic(fmt.Sprintf("failed to scale down old RS to zero replica: %v", err))
-						}
-
-						// Change rs variable to point to newRS
-						rs = newRS
-					} else {
-						// Scale down the new RS to zero replica
-						err = crdclient.ScaleReplicaSet(newRS, 0)
-						if err != nil {
-							panic(fmt.Sprintf("failed to scale down new RS to zero replica: %v", err))
-						}
-					}
+			OnNewLeader: func(identity string) {
+				if identity != lock.Identity() {
+					glog.Infof("Replica %s is the leader", identity)
 				}
 			},
 		},
-	)
-
-	stop := make(chan struct{})
-	go controller.Run(stop)
-
-	// Wait forever to ensure BGDeployment controller is running indefinitely
-	select {}
+	})
 }
@@ -0,0 +1,177 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	demov1 "k8s.io/bgd-operator/pkg/apis/demo/v1"
+	"k8s.io/client-go/tools/metrics"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// metricsNamespace prefixes the names of all the metrics of the operator
+const metricsNamespace = "bgd_operator"
+
+// Labels of the metrics of a single BGDeployment
+var bgdMetricLabels = []string{"namespace", "bgdeployment"}
+
+// rolloutDurationBuckets spans from a second to over half an hour, as the
+// default progress deadline is 10 minutes
+var rolloutDurationBuckets = prometheus.ExponentialBuckets(1, 2, 12)
+
+var (
+	rolloutsStarted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rollouts_started_total",
+		Help:      "Number of rollouts started, including rollbacks, per BGDeployment.",
+	}, bgdMetricLabels)
+	rolloutsSucceeded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rollouts_succeeded_total",
+		Help:      "Number of rollouts whose service was switched over to the new replicaset, per BGDeployment.",
+	}, bgdMetricLabels)
+	rolloutsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rollouts_failed_total",
+		Help:      "Number of rollouts whose pods did not become available within the progress deadline, per BGDeployment.",
+	}, bgdMetricLabels)
+	rollbacks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rollbacks_total",
+		Help:      "Number of rollbacks started, per BGDeployment.",
+	}, bgdMetricLabels)
+	rolloutReadyDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "rollout_ready_duration_seconds",
+		Help:      "Time from the start of a rollout until all pods of the new replicaset are available.",
+		Buckets:   rolloutDurationBuckets,
+	}, bgdMetricLabels)
+	rolloutSwitchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "rollout_switch_duration_seconds",
+		Help:      "Time from the start of a rollout until the service is switched over to the new replicaset.",
+		Buckets:   rolloutDurationBuckets,
+	}, bgdMetricLabels)
+	activeColor = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "active_color",
+		Help:      "Color the service of a BGDeployment points to: 1 for the active color, 0 for the other one.",
+	}, append(bgdMetricLabels, "color"))
+
+	reconcileDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Time taken by a single reconcile of a BGDeployment.",
+		Buckets:   prometheus.DefBuckets,
+	})
+	workqueueRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "workqueue",
+		Name:      "retries_total",
+		Help:      "Number of reconciles that failed and were put back on the workqueue.",
+	})
+	workqueueDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "workqueue",
+		Name:      "dropped_total",
+		Help:      "Number of BGDeployments dropped out of the workqueue after too many failed reconciles.",
+	})
+	apiErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_errors_total",
+		Help:      "Number of requests to the API server that failed, by HTTP status code and method.",
+	}, []string{"code", "method"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		rolloutsStarted,
+		rolloutsSucceeded,
+		rolloutsFailed,
+		rollbacks,
+		rolloutReadyDuration,
+		rolloutSwitchDuration,
+		activeColor,
+		reconcileDuration,
+		workqueueRetries,
+		workqueueDropped,
+		apiErrors,
+	)
+	metrics.Register(apiMetrics{}, apiMetrics{})
+}
+
+// apiMetrics counts the failed requests of the clients of the operator. It is
+// called by client-go for every request.
+type apiMetrics struct{}
+
+// Observe ignores the latency of requests
+func (apiMetrics) Observe(verb string, u url.URL, latency time.Duration) {}
+
+// Increment counts the requests that did not succeed. Requests that did not
+// get a response have the "<error>" code.
+func (apiMetrics) Increment(code, method, host string) {
+	if !strings.HasPrefix(code, "2") {
+		apiErrors.WithLabelValues(code, method).Inc()
+	}
+}
+
+// registerQueueMetrics exposes the number of BGDeployments waiting in the
+// workqueue, in place of the workqueue of an earlier controller, if any
+func registerQueueMetrics(queue workqueue.Interface) {
+	depth := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "workqueue",
+		Name:      "depth",
+		Help:      "Number of BGDeployments waiting in the workqueue.",
+	}, func() float64 {
+		return float64(queue.Len())
+	})
+	prometheus.Unregister(depth)
+	prometheus.MustRegister(depth)
+}
+
+// setActiveColor records the color the service of the BGDeployment points to
+func setActiveColor(bgd *demov1.BGDeployment, color string) {
+	activeColor.WithLabelValues(bgd.Namespace, bgd.Name, color).Set(1)
+	activeColor.WithLabelValues(bgd.Namespace, bgd.Name, colorMap[color]).Set(0)
+}
+
+// rolloutStartTime returns when the rollout of the new RS started: when the
+// rollback to it started, or else when it was created
+func rolloutStartTime(status *demov1.BGDeploymentStatus, newRS *appsv1.ReplicaSet) time.Time {
+	if status.LastRollback != nil && status.LastRollback.ToReplicaSet == newRS.Name {
+		return status.LastRollback.Time.Time
+	}
+	return newRS.CreationTimestamp.Time
+}
+
+// forgetBGDeploymentMetrics drops the metrics of a BGDeployment that is gone
+func forgetBGDeploymentMetrics(bgd *demov1.BGDeployment) {
+	for _, vec := range []*prometheus.CounterVec{rolloutsStarted, rolloutsSucceeded, rolloutsFailed, rollbacks} {
+		vec.DeleteLabelValues(bgd.Namespace, bgd.Name)
+	}
+	for _, vec := range []*prometheus.HistogramVec{rolloutReadyDuration, rolloutSwitchDuration} {
+		vec.DeleteLabelValues(bgd.Namespace, bgd.Name)
+	}
+	for color := range colorMap {
+		activeColor.DeleteLabelValues(bgd.Namespace, bgd.Name, color)
+	}
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	demov1 "k8s.io/bgd-operator/pkg/apis/demo/v1"
+)
+
+// legacyServiceName is the name of the service created by earlier versions of
+// the operator, one per namespace
+const legacyServiceName = "bgd-svc"
+
+// legacyReplicaSets returns the replicasets created for the BGDeployment by
+// earlier versions of the operator: the blue-rs and green-rs replicasets it
+// controls, which select their pods by color only and carry no bgdLabel.
+func (c *Controller) legacyReplicaSets(bgd *demov1.BGDeployment) ([]*appsv1.ReplicaSet, error) {
+	rss, err := c.replicaSets(bgd.Namespace).List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list RSs: %v", err)
+	}
+	var legacy []*appsv1.ReplicaSet
+	for _, rs := range rss {
+		if _, ok := rs.Labels[bgdLabel]; ok || !metav1.IsControlledBy(rs, bgd) {
+			continue
+		}
+		legacy = append(legacy, rs)
+	}
+	return legacy, nil
+}
+
+// legacyService returns the bgd-svc service created by earlier versions of the
+// operator, or nil if there is none. It has no owner and no bgdLabel, so a
+// service of that name that has either is not the legacy one.
+func (c *Controller) legacyService(bgd *demov1.BGDeployment) (*corev1.Service, error) {
+	svc, err := c.services(bgd.Namespace).Get(legacyServiceName)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service %q: %v", legacyServiceName, err)
+	}
+	if _, ok := svc.Labels[bgdLabel]; ok || metav1.GetControllerOf(svc) != nil {
+		return nil, nil
+	}
+	return svc, nil
+}
+
+// migrate replaces the legacy replicasets of the BGDeployment, and its legacy
+// service if any, without interrupting traffic:
+//
+//  1. A managed RS of the color the legacy service points to is created. Its
+//     pods carry the color label too, so the legacy service sends traffic to
+//     them as well once they are ready.
+//  2. Once its pods are all available, the legacy service is adopted when the
+//     BGDeployment uses its name, and otherwise replaced by a service of the
+//     BGDeployment.
+//  3. The legacy replicasets are deleted.
+//
+// rss are the managed replicasets of the BGDeployment. The outcome is filled
+// into status.
+func (c *Controller) migrate(bgd *demov1.BGDeployment, status *demov1.BGDeploymentStatus, legacyRSs, rss []*appsv1.ReplicaSet) error {
+	legacySvc, err := c.legacyService(bgd)
+	if err != nil {
+		return err
+	}
+	color := legacyColor(legacySvc, legacyRSs)
+	status.ActiveColor = color
+	status.PreviewColor = colorMap[color]
+
+	rs := replicaSetForColor(rss, color)
+	if rs == nil {
+		rs, err = c.createReplicaSet(color, bgd)
+		if err != nil {
+			return err
+		}
+	}
+	status.ActiveReplicaSet = replicaSetStatus(rs)
+	if !replicaSetAvailable(rs) {
+		// The RS informer brings the BGDeployment back as the pods of the
+		// RS become available
+		glog.V(2).Infof("migrating BGDeployment %q: waiting for the pods of RS %q to be available", bgd.Name, rs.Name)
+		status.Phase = demov1.BGDeploymentWaitingForReady
+		return nil
+	}
+
+	if legacySvc != nil {
+		if serviceName(bgd) == legacySvc.Name {
+			if err = c.adoptLegacyService(bgd, legacySvc, color); err != nil {
+				return err
+			}
+		} else {
+			_, err = c.services(bgd.Namespace).Get(serviceName(bgd))
+			if apierrors.IsNotFound(err) {
+				_, err = c.createService(bgd, newService(bgd, color))
+			}
+			if err != nil {
+				return fmt.Errorf("failed to create service: %v", err)
+			}
+			err = c.deleteService(bgd, legacySvc)
+			if err != nil && !apierrors.IsNotFound(err) {
+				return fmt.Errorf("failed to delete service %q: %v", legacySvc.Name, err)
+			}
+		}
+	}
+
+	for _, legacyRS := range legacyRSs {
+		err = c.deleteReplicaSet(bgd, legacyRS)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete RS %q: %v", legacyRS.Name, err)
+		}
+	}
+	glog.Infof("migrated BGDeployment %q to RS %q", bgd.Name, rs.Name)
+	c.recorder.Eventf(bgd, corev1.EventTypeNormal, migratedEvent, "Replaced the replicasets of an earlier version of the operator with RS %q", rs.Name)
+	// The RS informer brings the BGDeployment back once the legacy
+	// replicasets are gone
+	return nil
+}
+
+// adoptLegacyService turns the legacy service into the service of the
+// BGDeployment, sending traffic to the given color
+func (c *Controller) adoptLegacyService(bgd *demov1.BGDeployment, svc *corev1.Service, color string) error {
+	updated := svc.DeepCopy()
+	applyService(updated, newService(bgd, color))
+	patch, err := servicePatch(svc, updated)
+	if err != nil {
+		return fmt.Errorf("failed to compute the patch of service %q: %v", svc.Name, err)
+	}
+	if _, err = c.kubeclient.PatchService(svc.Name, svc.Namespace, patch); err != nil {
+		return fmt.Errorf("failed to adopt service %q: %v", svc.Name, err)
+	}
+	c.expectService(bgd, svc.Name, func(svc *corev1.Service) bool {
+		return svc == nil || svc.Labels[bgdLabel] == bgd.Name
+	})
+	return nil
+}
+
+// legacyColor returns the color the legacy service sends traffic to, or else
+// the color of the legacy RS that has replicas, or else blue
+func legacyColor(svc *corev1.Service, rss []*appsv1.ReplicaSet) string {
+	if svc != nil {
+		if color := svc.Spec.Selector[colorLabel]; colorMap[color] != "" {
+			return color
+		}
+	}
+	for _, rs := range rss {
+		if color := replicaSetColor(rs); colorMap[color] != "" && rs.Spec.Replicas != nil && *rs.Spec.Replicas > 0 {
+			return color
+		}
+	}
+	return "blue"
+}
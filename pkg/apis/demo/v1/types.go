@@ -17,11 +17,11 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // +genclient
-// +genclient:noStatus
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // BGDeployment is a specification for a BGDeployment resource
@@ -34,14 +34,218 @@ type BGDeployment struct {
 
 // BGDeploymentSpec is the spec for a BGDeployment resource
 type BGDeploymentSpec struct {
-	Image string `json:"image"`
+	// Number of desired pods of the color serving traffic, also used by the
+	// other color while it is rolled out. Defaults to 1.
+	Replicas *int32 `json:"replicas,omitempty"`
+	// Template describes the pods of the blue and green replicasets. Any
+	// change to it rolls out a new replicaset.
+	Template corev1.PodTemplateSpec `json:"template"`
+	// Image is the image of the single nginx container of BGDeployments
+	// created before Template existed. Deprecated: use Template instead,
+	// which takes precedence as soon as it has containers.
+	Image string `json:"image,omitempty"`
+	// Minimum number of seconds for which a newly created pod should be
+	// ready without any of its containers crashing, for it to be considered
+	// available. Defaults to 0 (available as soon as it is ready).
+	MinReadySeconds int32 `json:"minReadySeconds,omitempty"`
+	// The maximum time in seconds the pods of a new replicaset are given to
+	// become available before the rollout is considered failed and the new
+	// replicaset is scaled down. Defaults to 600s.
+	ProgressDeadlineSeconds *int32 `json:"progressDeadlineSeconds,omitempty"`
+	// Service describes the service sending traffic to the color that is
+	// currently active.
+	Service BGDeploymentServiceSpec `json:"service,omitempty"`
+	// PreviewService describes an optional second service, which always
+	// sends traffic to the color that is not active, so that a new version
+	// can be tried out before the service is switched over to it. Its name
+	// defaults to the name of the BGDeployment with a "-preview" suffix, and
+	// its ports to the ports of the service, without node ports.
+	PreviewService *BGDeploymentServiceSpec `json:"previewService,omitempty"`
+	// Strategy describes how a new replicaset is promoted.
+	Strategy BGDeploymentStrategy `json:"strategy,omitempty"`
+	// RollbackTo, when set, rolls the BGDeployment back to the replicaset
+	// that served traffic before the last rollout, or aborts the rollout in
+	// progress, if any. It is cleared once the rollback has started.
+	RollbackTo *BGDeploymentRollback `json:"rollbackTo,omitempty"`
+	// RetentionPolicy tells what happens to the replicasets and services of
+	// the BGDeployment when it is deleted: Delete or Orphan. Defaults to
+	// Delete.
+	RetentionPolicy BGDeploymentRetentionPolicy `json:"retentionPolicy,omitempty"`
+}
+
+// BGDeploymentRetentionPolicy is a valid value for
+// BGDeploymentSpec.RetentionPolicy
+type BGDeploymentRetentionPolicy string
+
+const (
+	// BGDeploymentRetentionDelete deletes the services, then the
+	// replicasets of a deleted BGDeployment.
+	BGDeploymentRetentionDelete BGDeploymentRetentionPolicy = "Delete"
+	// BGDeploymentRetentionOrphan keeps the services and replicasets of a
+	// deleted BGDeployment running, without an owner.
+	BGDeploymentRetentionOrphan BGDeploymentRetentionPolicy = "Orphan"
+)
+
+// BGDeploymentFinalizer holds the deletion of a BGDeployment until its
+// services and replicasets have been torn down.
+const BGDeploymentFinalizer = "demo.google.com/cleanup"
+
+// BGDeploymentRollback describes the target of a rollback
+type BGDeploymentRollback struct {
+	// The template hash of the replicaset to roll back to. The rollback is
+	// refused if the previous replicaset has another one. Empty means any.
+	TemplateHash string `json:"templateHash,omitempty"`
+}
+
+// BGDeploymentStrategy describes how a new replicaset of a BGDeployment is
+// promoted to serve traffic
+type BGDeploymentStrategy struct {
+	// AutoPromote switches the service over to a new replicaset as soon as
+	// its pods are available. When false, the switch waits until the
+	// BGDeployment is given the PromoteAnnotation. Defaults to true.
+	AutoPromote *bool `json:"autoPromote,omitempty"`
+}
+
+// PromoteAnnotation promotes the new replicaset named by its value, once it is
+// awaiting promotion. It is removed once the service has been switched over.
+// Any other value promotes nothing.
+const PromoteAnnotation = "demo.google.com/promote"
+
+// BGDeploymentServiceSpec is the spec for the service of a BGDeployment
+type BGDeploymentServiceSpec struct {
+	// Name of the service. Defaults to the name of the BGDeployment.
+	Name string `json:"name,omitempty"`
+	// Labels added to the service.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Annotations added to the service.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Type of the service: ClusterIP, NodePort or LoadBalancer. Defaults to
+	// ClusterIP.
+	Type corev1.ServiceType `json:"type,omitempty"`
+	// Ports exposed by the service. Defaults to port 80 sent to port 443 of
+	// the pods over TCP.
+	Ports []corev1.ServicePort `json:"ports,omitempty"`
+	// Session affinity of the service: ClientIP or None. Defaults to None.
+	SessionAffinity corev1.ServiceAffinity `json:"sessionAffinity,omitempty"`
 }
 
 // BGDeploymentStatus is the status for a BGDeployment resource
 type BGDeploymentStatus struct {
+	// The generation of the BGDeployment observed by the last sync.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// The step the last rollout of the BGDeployment has reached.
 	Phase string `json:"phase,omitempty"`
+	// A human readable message indicating why the last sync failed.
+	Message string `json:"message,omitempty"`
+	// Total number of pods of the color serving traffic.
+	Replicas int32 `json:"replicas,omitempty"`
+	// Label selector of the pods of the color serving traffic, in the string
+	// form expected by the scale subresource.
+	Selector string `json:"selector,omitempty"`
+	// The color the service sends traffic to.
+	ActiveColor string `json:"activeColor,omitempty"`
+	// The color the preview service sends traffic to, i.e. the color that
+	// is not active.
+	PreviewColor string `json:"previewColor,omitempty"`
+	// The replicaset of the active color.
+	ActiveReplicaSet *BGDeploymentReplicaSetStatus `json:"activeReplicaSet,omitempty"`
+	// The replicaset of the preview color, if any.
+	PreviewReplicaSet *BGDeploymentReplicaSetStatus `json:"previewReplicaSet,omitempty"`
+	// The last time the service was switched over to a new replicaset.
+	LastPromotionTime *metav1.Time `json:"lastPromotionTime,omitempty"`
+	// The last rollback of the BGDeployment.
+	LastRollback *BGDeploymentRollbackStatus `json:"lastRollback,omitempty"`
+	// Represents the latest available observations of the state of the
+	// BGDeployment.
+	Conditions []BGDeploymentCondition `json:"conditions,omitempty"`
 }
 
+// BGDeploymentConditionType is a valid value for BGDeploymentCondition.Type
+type BGDeploymentConditionType string
+
+// These are valid conditions of a BGDeployment.
+const (
+	// Available means the replicaset of the active color has all of its
+	// pods available.
+	BGDeploymentAvailable BGDeploymentConditionType = "Available"
+	// Progressing means the rollout of a new replicaset is in progress, or
+	// has completed. It becomes false with the ProgressDeadlineExceeded
+	// reason when the pods of the new replicaset do not become available
+	// within spec.progressDeadlineSeconds.
+	BGDeploymentProgressing BGDeploymentConditionType = "Progressing"
+	// Degraded means the last sync of the BGDeployment failed, and the
+	// message of the condition tells why.
+	BGDeploymentDegraded BGDeploymentConditionType = "Degraded"
+)
+
+// BGDeploymentCondition describes the state of a BGDeployment at a certain
+// point
+type BGDeploymentCondition struct {
+	// Type of BGDeployment condition.
+	Type BGDeploymentConditionType `json:"type"`
+	// Status of the condition, one of True, False, Unknown.
+	Status corev1.ConditionStatus `json:"status"`
+	// The last time this condition was updated.
+	LastUpdateTime metav1.Time `json:"lastUpdateTime,omitempty"`
+	// Last time the condition transitioned from one status to another.
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// The reason for the condition's last transition.
+	Reason string `json:"reason,omitempty"`
+	// A human readable message indicating details about the transition.
+	Message string `json:"message,omitempty"`
+}
+
+// BGDeploymentReplicaSetStatus describes a replicaset of a BGDeployment
+type BGDeploymentReplicaSetStatus struct {
+	// Name of the replicaset.
+	Name string `json:"name"`
+	// The hash of the pod template of the replicaset.
+	TemplateHash string `json:"templateHash,omitempty"`
+	// Number of desired pods.
+	Replicas int32 `json:"replicas"`
+	// Number of ready pods.
+	ReadyReplicas int32 `json:"readyReplicas"`
+	// Number of available pods (ready for at least minReadySeconds).
+	AvailableReplicas int32 `json:"availableReplicas"`
+}
+
+// BGDeploymentRollbackStatus records a rollback of a BGDeployment
+type BGDeploymentRollbackStatus struct {
+	// Time the rollback started.
+	Time metav1.Time `json:"time"`
+	// The replicaset that served traffic before the rollback.
+	FromReplicaSet string `json:"fromReplicaSet"`
+	// The replicaset that was rolled back to.
+	ToReplicaSet string `json:"toReplicaSet"`
+}
+
+// These are the valid phases of a BGDeployment. A rollout goes through them in
+// order, one at a time, from Provisioning to Completed.
+const (
+	// BGDeploymentProvisioning means a new replicaset is being created for
+	// the pod template of the spec, once the replicaset of the inactive
+	// color running an older template is deleted.
+	BGDeploymentProvisioning = "Provisioning"
+	// BGDeploymentWaitingForReady means the pods of the new replicaset are
+	// not all available yet.
+	BGDeploymentWaitingForReady = "WaitingForReady"
+	// BGDeploymentAwaitingPromotion means the pods of a new replicaset are
+	// available, and the service is switched over to them once the
+	// BGDeployment is promoted.
+	BGDeploymentAwaitingPromotion = "AwaitingPromotion"
+	// BGDeploymentPromoting means the service is being switched over to the
+	// new replicaset.
+	BGDeploymentPromoting = "Promoting"
+	// BGDeploymentScalingDownOld means the service points to the new
+	// replicaset, and the old replicaset is being scaled down.
+	BGDeploymentScalingDownOld = "ScalingDownOld"
+	// BGDeploymentCompleted means the service points to a replicaset running
+	// the pod template of the spec, and no rollout is in progress.
+	BGDeploymentCompleted = "Completed"
+	// BGDeploymentFailed means the last sync of the BGDeployment failed.
+	BGDeploymentFailed = "Failed"
+)
+
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // BGDeploymentList is a list of BGDeployment resources
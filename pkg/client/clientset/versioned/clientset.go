@@ -1,5 +1,5 @@
 /*
-Copyright 2017 The Kubernetes Authors.
+Copyright The Kubernetes Authors.
 
 Licensed under the Apache License, Version 2.0 (the "License");
 you may not use this file except in compliance with the License.
@@ -14,10 +14,11 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
+// Code generated by client-gen. DO NOT EDIT.
+
 package versioned
 
 import (
-	glog "github.com/golang/glog"
 	demov1 "k8s.io/bgd-operator/pkg/client/clientset/versioned/typed/demo/v1"
 	discovery "k8s.io/client-go/discovery"
 	rest "k8s.io/client-go/rest"
@@ -27,8 +28,6 @@ import (
 type Interface interface {
 	Discovery() discovery.DiscoveryInterface
 	DemoV1() demov1.DemoV1Interface
-	// Deprecated: please explicitly pick a version if possible.
-	Demo() demov1.DemoV1Interface
 }
 
 // Clientset contains the clients for groups. Each group has exactly one
@@ -43,12 +42,6 @@ func (c *Clientset) DemoV1() demov1.DemoV1Interface {
 	return c.demoV1
 }
 
-// Deprecated: Demo retrieves the default version of DemoClient.
-// Please explicitly pick a version.
-func (c *Clientset) Demo() demov1.DemoV1Interface {
-	return c.demoV1
-}
-
 // Discovery retrieves the DiscoveryClient
 func (c *Clientset) Discovery() discovery.DiscoveryInterface {
 	if c == nil {
@@ -72,7 +65,6 @@ func NewForConfig(c *rest.Config) (*Clientset, error) {
 
 	cs.DiscoveryClient, err = discovery.NewDiscoveryClientForConfig(&configShallowCopy)
 	if err != nil {
-		glog.Errorf("failed to create the DiscoveryClient: %v", err)
 		return nil, err
 	}
 	return &cs, nil
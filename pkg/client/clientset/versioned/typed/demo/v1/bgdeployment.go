@@ -1,5 +1,5 @@
 /*
-Copyright 2017 The Kubernetes Authors.
+Copyright The Kubernetes Authors.
 
 Licensed under the Apache License, Version 2.0 (the "License");
 you may not use this file except in compliance with the License.
@@ -14,10 +14,14 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
+// Code generated by client-gen. DO NOT EDIT.
+
 package v1
 
 import (
-	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	types "k8s.io/apimachinery/pkg/types"
 	watch "k8s.io/apimachinery/pkg/watch"
 	v1 "k8s.io/bgd-operator/pkg/apis/demo/v1"
@@ -35,11 +39,12 @@ type BGDeploymentsGetter interface {
 type BGDeploymentInterface interface {
 	Create(*v1.BGDeployment) (*v1.BGDeployment, error)
 	Update(*v1.BGDeployment) (*v1.BGDeployment, error)
-	Delete(name string, options *meta_v1.DeleteOptions) error
-	DeleteCollection(options *meta_v1.DeleteOptions, listOptions meta_v1.ListOptions) error
-	Get(name string, options meta_v1.GetOptions) (*v1.BGDeployment, error)
-	List(opts meta_v1.ListOptions) (*v1.BGDeploymentList, error)
-	Watch(opts meta_v1.ListOptions) (watch.Interface, error)
+	UpdateStatus(*v1.BGDeployment) (*v1.BGDeployment, error)
+	Delete(name string, options *metav1.DeleteOptions) error
+	DeleteCollection(options *metav1.DeleteOptions, listOptions metav1.ListOptions) error
+	Get(name string, options metav1.GetOptions) (*v1.BGDeployment, error)
+	List(opts metav1.ListOptions) (*v1.BGDeploymentList, error)
+	Watch(opts metav1.ListOptions) (watch.Interface, error)
 	Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1.BGDeployment, err error)
 	BGDeploymentExpansion
 }
@@ -59,7 +64,7 @@ func newBGDeployments(c *DemoV1Client, namespace string) *bGDeployments {
 }
 
 // Get takes name of the bGDeployment, and returns the corresponding bGDeployment object, and an error if there is any.
-func (c *bGDeployments) Get(name string, options meta_v1.GetOptions) (result *v1.BGDeployment, err error) {
+func (c *bGDeployments) Get(name string, options metav1.GetOptions) (result *v1.BGDeployment, err error) {
 	result = &v1.BGDeployment{}
 	err = c.client.Get().
 		Namespace(c.ns).
@@ -72,24 +77,34 @@ func (c *bGDeployments) Get(name string, options meta_v1.GetOptions) (result *v1
 }
 
 // List takes label and field selectors, and returns the list of BGDeployments that match those selectors.
-func (c *bGDeployments) List(opts meta_v1.ListOptions) (result *v1.BGDeploymentList, err error) {
+func (c *bGDeployments) List(opts metav1.ListOptions) (result *v1.BGDeploymentList, err error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
 	result = &v1.BGDeploymentList{}
 	err = c.client.Get().
 		Namespace(c.ns).
 		Resource("bgdeployments").
 		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
 		Do().
 		Into(result)
 	return
 }
 
 // Watch returns a watch.Interface that watches the requested bGDeployments.
-func (c *bGDeployments) Watch(opts meta_v1.ListOptions) (watch.Interface, error) {
+func (c *bGDeployments) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
 	opts.Watch = true
 	return c.client.Get().
 		Namespace(c.ns).
 		Resource("bgdeployments").
 		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
 		Watch()
 }
 
@@ -118,8 +133,24 @@ func (c *bGDeployments) Update(bGDeployment *v1.BGDeployment) (result *v1.BGDepl
 	return
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+
+func (c *bGDeployments) UpdateStatus(bGDeployment *v1.BGDeployment) (result *v1.BGDeployment, err error) {
+	result = &v1.BGDeployment{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("bgdeployments").
+		Name(bGDeployment.Name).
+		SubResource("status").
+		Body(bGDeployment).
+		Do().
+		Into(result)
+	return
+}
+
 // Delete takes name of the bGDeployment and deletes it. Returns an error if one occurs.
-func (c *bGDeployments) Delete(name string, options *meta_v1.DeleteOptions) error {
+func (c *bGDeployments) Delete(name string, options *metav1.DeleteOptions) error {
 	return c.client.Delete().
 		Namespace(c.ns).
 		Resource("bgdeployments").
@@ -130,11 +161,16 @@ func (c *bGDeployments) Delete(name string, options *meta_v1.DeleteOptions) erro
 }
 
 // DeleteCollection deletes a collection of objects.
-func (c *bGDeployments) DeleteCollection(options *meta_v1.DeleteOptions, listOptions meta_v1.ListOptions) error {
+func (c *bGDeployments) DeleteCollection(options *metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+	var timeout time.Duration
+	if listOptions.TimeoutSeconds != nil {
+		timeout = time.Duration(*listOptions.TimeoutSeconds) * time.Second
+	}
 	return c.client.Delete().
 		Namespace(c.ns).
 		Resource("bgdeployments").
 		VersionedParams(&listOptions, scheme.ParameterCodec).
+		Timeout(timeout).
 		Body(options).
 		Do().
 		Error()
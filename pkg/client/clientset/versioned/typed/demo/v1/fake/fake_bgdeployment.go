@@ -1,5 +1,5 @@
 /*
-Copyright 2017 The Kubernetes Authors.
+Copyright The Kubernetes Authors.
 
 Licensed under the Apache License, Version 2.0 (the "License");
 you may not use this file except in compliance with the License.
@@ -14,6 +14,8 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
+// Code generated by client-gen. DO NOT EDIT.
+
 package fake
 
 import (
@@ -22,7 +24,7 @@ import (
 	schema "k8s.io/apimachinery/pkg/runtime/schema"
 	types "k8s.io/apimachinery/pkg/types"
 	watch "k8s.io/apimachinery/pkg/watch"
-	demo_v1 "k8s.io/bgd-operator/pkg/apis/demo/v1"
+	demov1 "k8s.io/bgd-operator/pkg/apis/demo/v1"
 	testing "k8s.io/client-go/testing"
 )
 
@@ -37,20 +39,20 @@ var bgdeploymentsResource = schema.GroupVersionResource{Group: "demo.google.com"
 var bgdeploymentsKind = schema.GroupVersionKind{Group: "demo.google.com", Version: "v1", Kind: "BGDeployment"}
 
 // Get takes name of the bGDeployment, and returns the corresponding bGDeployment object, and an error if there is any.
-func (c *FakeBGDeployments) Get(name string, options v1.GetOptions) (result *demo_v1.BGDeployment, err error) {
+func (c *FakeBGDeployments) Get(name string, options v1.GetOptions) (result *demov1.BGDeployment, err error) {
 	obj, err := c.Fake.
-		Invokes(testing.NewGetAction(bgdeploymentsResource, c.ns, name), &demo_v1.BGDeployment{})
+		Invokes(testing.NewGetAction(bgdeploymentsResource, c.ns, name), &demov1.BGDeployment{})
 
 	if obj == nil {
 		return nil, err
 	}
-	return obj.(*demo_v1.BGDeployment), err
+	return obj.(*demov1.BGDeployment), err
 }
 
 // List takes label and field selectors, and returns the list of BGDeployments that match those selectors.
-func (c *FakeBGDeployments) List(opts v1.ListOptions) (result *demo_v1.BGDeploymentList, err error) {
+func (c *FakeBGDeployments) List(opts v1.ListOptions) (result *demov1.BGDeploymentList, err error) {
 	obj, err := c.Fake.
-		Invokes(testing.NewListAction(bgdeploymentsResource, bgdeploymentsKind, c.ns, opts), &demo_v1.BGDeploymentList{})
+		Invokes(testing.NewListAction(bgdeploymentsResource, bgdeploymentsKind, c.ns, opts), &demov1.BGDeploymentList{})
 
 	if obj == nil {
 		return nil, err
@@ -60,8 +62,8 @@ func (c *FakeBGDeployments) List(opts v1.ListOptions) (result *demo_v1.BGDeploym
 	if label == nil {
 		label = labels.Everything()
 	}
-	list := &demo_v1.BGDeploymentList{}
-	for _, item := range obj.(*demo_v1.BGDeploymentList).Items {
+	list := &demov1.BGDeploymentList{ListMeta: obj.(*demov1.BGDeploymentList).ListMeta}
+	for _, item := range obj.(*demov1.BGDeploymentList).Items {
 		if label.Matches(labels.Set(item.Labels)) {
 			list.Items = append(list.Items, item)
 		}
@@ -77,31 +79,43 @@ func (c *FakeBGDeployments) Watch(opts v1.ListOptions) (watch.Interface, error)
 }
 
 // Create takes the representation of a bGDeployment and creates it.  Returns the server's representation of the bGDeployment, and an error, if there is any.
-func (c *FakeBGDeployments) Create(bGDeployment *demo_v1.BGDeployment) (result *demo_v1.BGDeployment, err error) {
+func (c *FakeBGDeployments) Create(bGDeployment *demov1.BGDeployment) (result *demov1.BGDeployment, err error) {
 	obj, err := c.Fake.
-		Invokes(testing.NewCreateAction(bgdeploymentsResource, c.ns, bGDeployment), &demo_v1.BGDeployment{})
+		Invokes(testing.NewCreateAction(bgdeploymentsResource, c.ns, bGDeployment), &demov1.BGDeployment{})
 
 	if obj == nil {
 		return nil, err
 	}
-	return obj.(*demo_v1.BGDeployment), err
+	return obj.(*demov1.BGDeployment), err
 }
 
 // Update takes the representation of a bGDeployment and updates it. Returns the server's representation of the bGDeployment, and an error, if there is any.
-func (c *FakeBGDeployments) Update(bGDeployment *demo_v1.BGDeployment) (result *demo_v1.BGDeployment, err error) {
+func (c *FakeBGDeployments) Update(bGDeployment *demov1.BGDeployment) (result *demov1.BGDeployment, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateAction(bgdeploymentsResource, c.ns, bGDeployment), &demov1.BGDeployment{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*demov1.BGDeployment), err
+}
+
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeBGDeployments) UpdateStatus(bGDeployment *demov1.BGDeployment) (*demov1.BGDeployment, error) {
 	obj, err := c.Fake.
-		Invokes(testing.NewUpdateAction(bgdeploymentsResource, c.ns, bGDeployment), &demo_v1.BGDeployment{})
+		Invokes(testing.NewUpdateSubresourceAction(bgdeploymentsResource, "status", c.ns, bGDeployment), &demov1.BGDeployment{})
 
 	if obj == nil {
 		return nil, err
 	}
-	return obj.(*demo_v1.BGDeployment), err
+	return obj.(*demov1.BGDeployment), err
 }
 
 // Delete takes name of the bGDeployment and deletes it. Returns an error if one occurs.
 func (c *FakeBGDeployments) Delete(name string, options *v1.DeleteOptions) error {
 	_, err := c.Fake.
-		Invokes(testing.NewDeleteAction(bgdeploymentsResource, c.ns, name), &demo_v1.BGDeployment{})
+		Invokes(testing.NewDeleteAction(bgdeploymentsResource, c.ns, name), &demov1.BGDeployment{})
 
 	return err
 }
@@ -110,17 +124,17 @@ func (c *FakeBGDeployments) Delete(name string, options *v1.DeleteOptions) error
 func (c *FakeBGDeployments) DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error {
 	action := testing.NewDeleteCollectionAction(bgdeploymentsResource, c.ns, listOptions)
 
-	_, err := c.Fake.Invokes(action, &demo_v1.BGDeploymentList{})
+	_, err := c.Fake.Invokes(action, &demov1.BGDeploymentList{})
 	return err
 }
 
 // Patch applies the patch and returns the patched bGDeployment.
-func (c *FakeBGDeployments) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *demo_v1.BGDeployment, err error) {
+func (c *FakeBGDeployments) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *demov1.BGDeployment, err error) {
 	obj, err := c.Fake.
-		Invokes(testing.NewPatchSubresourceAction(bgdeploymentsResource, c.ns, name, data, subresources...), &demo_v1.BGDeployment{})
+		Invokes(testing.NewPatchSubresourceAction(bgdeploymentsResource, c.ns, name, pt, data, subresources...), &demov1.BGDeployment{})
 
 	if obj == nil {
 		return nil, err
 	}
-	return obj.(*demo_v1.BGDeployment), err
+	return obj.(*demov1.BGDeployment), err
 }